@@ -0,0 +1,103 @@
+package session
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// BindingMode controls what happens when a request presents a session
+// whose stored fingerprint does not match the request.
+type BindingMode int
+
+const (
+	// BindingOff disables fingerprint checks.
+	BindingOff BindingMode = iota
+	// BindingStrict rejects the session with ErrSessionBindingMismatch.
+	BindingStrict
+	// BindingLenient keeps the session but unauthenticates it.
+	BindingLenient
+	// BindingLogOnly only logs the mismatch and calls the hook.
+	BindingLogOnly
+)
+
+// FingerprintFunc extracts the raw client characteristics a session is
+// bound to. The middleware stores a SHA-256 hash of the result.
+type FingerprintFunc func(r *http.Request) string
+
+// BindingMismatchHook is called for every request whose fingerprint does
+// not match its session, in all modes other than BindingOff.
+type BindingMismatchHook func(r *http.Request, session SessionData)
+
+// UserAgentFingerprint binds sessions to the User-Agent header.
+func UserAgentFingerprint(r *http.Request) string {
+	return r.UserAgent()
+}
+
+// IPPrefixFingerprint binds sessions to the network of the client: the
+// first v4Bits of an IPv4 address or v6Bits of an IPv6 address. The
+// trusted proxies are handled as in WithTrustedProxies.
+func IPPrefixFingerprint(v4Bits, v6Bits int, trustedProxies ...string) FingerprintFunc {
+	trusted := parseTrustedProxies(trustedProxies)
+
+	return func(r *http.Request) string {
+		addr, err := netip.ParseAddr(clientIP(r, trusted))
+		if err != nil {
+			return ""
+		}
+
+		bits := v6Bits
+		if addr.Is4() {
+			bits = v4Bits
+		}
+
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			return addr.String()
+		}
+		return prefix.String()
+	}
+}
+
+// TLSChannelBindingFingerprint binds sessions to the TLS connection using
+// the tls-exporter channel binding (RFC 9266). Clients that open a new
+// connection get a new value, so this suits long-lived connections only.
+func TLSChannelBindingFingerprint(r *http.Request) string {
+	if r.TLS == nil {
+		return ""
+	}
+
+	binding, err := r.TLS.ExportKeyingMaterial("EXPORTER-Channel-Binding", nil, 32)
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(binding)
+}
+
+// CombineFingerprints binds sessions to all of the given fingerprints.
+func CombineFingerprints(fns ...FingerprintFunc) FingerprintFunc {
+	return func(r *http.Request) string {
+		parts := make([]string, len(fns))
+		for i, fn := range fns {
+			parts[i] = fn(r)
+		}
+		return strings.Join(parts, "\x00")
+	}
+}
+
+// hashFingerprint returns the value stored in SessionData.Fingerprint.
+func hashFingerprint(raw string) string {
+	if raw == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+func fingerprintsEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
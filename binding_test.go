@@ -0,0 +1,41 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIPPrefixFingerprint(t *testing.T) {
+	fingerprint := IPPrefixFingerprint(24, 64)
+
+	request := func(remoteAddr string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		return r
+	}
+
+	assert.Equal(t, "203.0.113.0/24", fingerprint(request("203.0.113.7:1234")))
+	assert.Equal(t, fingerprint(request("203.0.113.7:1234")), fingerprint(request("203.0.113.200:80")))
+	assert.NotEqual(t, fingerprint(request("203.0.113.7:1234")), fingerprint(request("203.0.114.7:1234")))
+	assert.Equal(t, "2001:db8:1:2::/64", fingerprint(request("[2001:db8:1:2::7]:1234")))
+}
+
+func TestCombineFingerprints(t *testing.T) {
+	fingerprint := CombineFingerprints(UserAgentFingerprint, IPPrefixFingerprint(16, 48))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "203.0.113.7:1234"
+	r.Header.Set("User-Agent", "agent")
+
+	assert.Equal(t, "agent\x00203.0.0.0/16", fingerprint(r))
+}
+
+func TestHashFingerprint(t *testing.T) {
+	assert.Empty(t, hashFingerprint(""))
+	assert.Len(t, hashFingerprint("agent"), 64)
+	assert.True(t, fingerprintsEqual(hashFingerprint("agent"), hashFingerprint("agent")))
+	assert.False(t, fingerprintsEqual(hashFingerprint("agent"), hashFingerprint("other")))
+}
@@ -0,0 +1,26 @@
+package session
+
+import "maps"
+
+// Changes describes how a session's Data differs from the stored copy.
+type Changes struct {
+	// Added holds keys that did not exist before.
+	Added map[string]any
+	// Changed holds existing keys that were set to a new value.
+	Changed map[string]any
+	// Deleted lists existing keys that were removed, sorted.
+	Deleted []string
+}
+
+// IsEmpty reports whether no key changed.
+func (c Changes) IsEmpty() bool {
+	return len(c.Added) == 0 && len(c.Changed) == 0 && len(c.Deleted) == 0
+}
+
+// Upserts returns the added and changed keys together.
+func (c Changes) Upserts() map[string]any {
+	upserts := make(map[string]any, len(c.Added)+len(c.Changed))
+	maps.Copy(upserts, c.Added)
+	maps.Copy(upserts, c.Changed)
+	return upserts
+}
@@ -0,0 +1,195 @@
+package session
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"reflect"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec serializes session data for stores that keep it outside the
+// process.
+type Codec interface {
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// CodecProvider is implemented by stores that serialize sessions with a
+// Codec. GetAs decodes values through the codec of the store the session
+// belongs to.
+type CodecProvider interface {
+	Codec() Codec
+}
+
+var (
+	JSONCodec    Codec = jsonCodec{}
+	GobCodec     Codec = gobCodec{}
+	MsgpackCodec Codec = msgpackCodec{}
+	CBORCodec    Codec = newCBORCodec()
+)
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{}
+)
+
+func init() {
+	for _, c := range []Codec{JSONCodec, GobCodec, MsgpackCodec, CBORCodec} {
+		RegisterCodec(c)
+	}
+
+	gob.Register(map[string]any{})
+	gob.Register([]any{})
+	gob.Register(map[string][]any{})
+}
+
+// RegisterCodec makes c available to DecodePayload under c.Name().
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[c.Name()] = c
+}
+
+func LookupCodec(name string) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[name]
+	return c, ok
+}
+
+// EncodePayload marshals v with c and prefixes the result with the codec
+// name ("gob:..."), so the payload can still be decoded after the store
+// switches codecs. JSON payloads are written untagged, exactly as before
+// codecs existed.
+func EncodePayload(c Codec, v any) ([]byte, error) {
+	data, err := c.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Name() == JSONCodec.Name() {
+		return data, nil
+	}
+
+	return append([]byte(c.Name()+":"), data...), nil
+}
+
+// DecodePayload unmarshals a payload written by EncodePayload into v with
+// the codec named in its prefix. Untagged payloads are decoded as JSON.
+func DecodePayload(data []byte, v any) error {
+	return payloadCodec(data).Unmarshal(payloadBody(data), v)
+}
+
+func payloadCodec(data []byte) Codec {
+	if name, _, ok := bytes.Cut(data, []byte(":")); ok {
+		if c, ok := LookupCodec(string(name)); ok {
+			return c
+		}
+	}
+	return JSONCodec
+}
+
+func payloadBody(data []byte) []byte {
+	if c := payloadCodec(data); c.Name() != JSONCodec.Name() {
+		return data[len(c.Name())+1:]
+	}
+	return data
+}
+
+func storeCodec(store Store) Codec {
+	if cp, ok := store.(CodecProvider); ok {
+		return cp.Codec()
+	}
+	return JSONCodec
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                       { return "json" }
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+// gobCodec keeps Go types intact. Concrete types stored behind `any` (such
+// as your own structs in Session.Data) must be registered with
+// gob.Register. SetAs registers them on first use, but a process that only
+// reads sessions must register them itself before loading one.
+type gobCodec struct{}
+
+// registerGob registers the concrete type of v with gob so it can be
+// encoded behind `any`. Types the caller already registered under another
+// name are left alone.
+func registerGob(v any) {
+	if v == nil {
+		return
+	}
+	defer func() { _ = recover() }()
+	gob.Register(v)
+}
+
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// msgpackCodec uses the json struct tags so field names match the JSON
+// encoding.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+// cborCodec keeps nanosecond timestamps and decodes maps as
+// map[string]any, matching the other codecs.
+type cborCodec struct {
+	enc cbor.EncMode
+	dec cbor.DecMode
+}
+
+func newCBORCodec() cborCodec {
+	enc, err := cbor.EncOptions{Time: cbor.TimeRFC3339Nano}.EncMode()
+	if err != nil {
+		panic("session: cbor encoder: " + err.Error())
+	}
+
+	dec, err := cbor.DecOptions{
+		DefaultMapType: reflect.TypeOf(map[string]any(nil)),
+	}.DecMode()
+	if err != nil {
+		panic("session: cbor decoder: " + err.Error())
+	}
+
+	return cborCodec{enc: enc, dec: dec}
+}
+
+func (cborCodec) Name() string                         { return "cbor" }
+func (c cborCodec) Marshal(v any) ([]byte, error)      { return c.enc.Marshal(v) }
+func (c cborCodec) Unmarshal(data []byte, v any) error { return c.dec.Unmarshal(data, v) }
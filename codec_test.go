@@ -0,0 +1,105 @@
+package session
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodecs_RoundTrip(t *testing.T) {
+	for _, codec := range []Codec{JSONCodec, GobCodec, MsgpackCodec, CBORCodec} {
+		t.Run("should round trip session data with "+codec.Name(), func(t *testing.T) {
+			data := NewSessionData(time.Hour)
+			data.Set("role", "admin")
+			data.Set("nested", map[string]any{"key": "value"})
+			data.Authenticate("user-1")
+
+			payload, err := EncodePayload(codec, &data)
+			require.NoError(t, err)
+
+			var decoded SessionData
+			require.NoError(t, DecodePayload(payload, &decoded))
+
+			assert.Equal(t, data.ID, decoded.ID)
+			assert.Equal(t, "admin", decoded.Data["role"])
+			assert.Equal(t, map[string]any{"key": "value"}, decoded.Data["nested"])
+			assert.True(t, decoded.Authenticated)
+			assert.Equal(t, "user-1", decoded.UserID)
+			assert.True(t, data.ExpiresAt.Equal(decoded.ExpiresAt))
+		})
+	}
+}
+
+func TestEncodePayload(t *testing.T) {
+	t.Run("should leave JSON payloads untagged", func(t *testing.T) {
+		payload, err := EncodePayload(JSONCodec, map[string]any{"a": 1})
+		require.NoError(t, err)
+		assert.Equal(t, `{"a":1}`, string(payload))
+	})
+
+	t.Run("should tag other payloads with the codec name", func(t *testing.T) {
+		payload, err := EncodePayload(MsgpackCodec, map[string]any{"a": 1})
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(payload), "msgpack:"))
+	})
+}
+
+func TestDecodePayload(t *testing.T) {
+	t.Run("should decode payloads from any registered codec", func(t *testing.T) {
+		for _, codec := range []Codec{JSONCodec, GobCodec, MsgpackCodec, CBORCodec} {
+			payload, err := EncodePayload(codec, map[string]any{"a": "b"})
+			require.NoError(t, err)
+
+			var v map[string]any
+			require.NoError(t, DecodePayload(payload, &v), codec.Name())
+			assert.Equal(t, "b", v["a"], codec.Name())
+		}
+	})
+
+	t.Run("should fail on corrupt payloads", func(t *testing.T) {
+		var v map[string]any
+		assert.Error(t, DecodePayload([]byte("gob:not gob"), &v))
+		assert.Error(t, DecodePayload([]byte("{ invalid json }"), &v))
+	})
+}
+
+func TestLookupCodec(t *testing.T) {
+	t.Run("should find the bundled codecs", func(t *testing.T) {
+		for _, name := range []string{"json", "gob", "msgpack", "cbor"} {
+			c, ok := LookupCodec(name)
+			assert.True(t, ok, name)
+			assert.Equal(t, name, c.Name())
+		}
+
+		_, ok := LookupCodec("xml")
+		assert.False(t, ok)
+	})
+}
+
+func TestGetAs_Codecs(t *testing.T) {
+	for _, codec := range []Codec{JSONCodec, MsgpackCodec, CBORCodec} {
+		t.Run("should convert values decoded by "+codec.Name(), func(t *testing.T) {
+			s := NewSession(time.Hour)
+			SetAs(s, "count", 42)
+			SetAs(s, "cart", cart{Items: []string{"a"}, Total: 10})
+
+			payload, err := EncodePayload(codec, s.GetSessionData())
+			require.NoError(t, err)
+			var sd SessionData
+			require.NoError(t, DecodePayload(payload, &sd))
+			loaded := NewSessionFromData(sd)
+			loaded.codec = codec
+
+			count, err := GetAs[int](loaded, "count")
+			require.NoError(t, err)
+			assert.Equal(t, 42, count)
+
+			c, err := GetAs[cart](loaded, "cart")
+			require.NoError(t, err)
+			assert.Equal(t, cart{Items: []string{"a"}, Total: 10}, c)
+		})
+	}
+}
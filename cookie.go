@@ -0,0 +1,145 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultCookieChunkSize leaves room for the cookie name and attributes
+	// under the 4096 byte limit browsers apply to a whole cookie.
+	defaultCookieChunkSize = 3800
+	maxCookieChunks        = 16
+)
+
+// CookieConfig describes the session cookie written by a cookie transport.
+type CookieConfig struct {
+	Name      string
+	Path      string
+	HTTPOnly  bool
+	Secure    bool
+	SameSite  http.SameSite
+	MaxAge    time.Duration
+	ChunkSize int
+}
+
+type cookieTransport struct {
+	cfg CookieConfig
+}
+
+// NewCookieTransport carries the session in a cookie, split across
+// numbered chunk cookies (sid.0, sid.1, ...) when the value is longer than
+// cfg.ChunkSize.
+func NewCookieTransport(cfg CookieConfig) Transport {
+	return &cookieTransport{cfg: cfg}
+}
+
+func (t *cookieTransport) newCookie(name, value string) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     t.cfg.Path,
+		Secure:   t.cfg.Secure,
+		HttpOnly: t.cfg.HTTPOnly,
+		SameSite: t.cfg.SameSite,
+	}
+}
+
+func (t *cookieTransport) chunkName(i int) string {
+	return t.cfg.Name + "." + strconv.Itoa(i)
+}
+
+// Write writes value under the cookie name, or across numbered chunk
+// cookies when it does not fit in one. Cookies from an earlier write that
+// the request still carries and that are no longer part of the value are
+// expired. A value needing more than maxCookieChunks chunks could not be
+// read back, so nothing is written and ErrCookieTooLarge is returned.
+func (t *cookieTransport) Write(w http.ResponseWriter, r *http.Request, value string, expiresAt time.Time) error {
+	set := func(name, value string) {
+		c := t.newCookie(name, value)
+		c.Expires = expiresAt
+		c.MaxAge = int(t.cfg.MaxAge.Seconds())
+		http.SetCookie(w, c)
+	}
+
+	if t.cfg.ChunkSize <= 0 || len(value) <= t.cfg.ChunkSize {
+		set(t.cfg.Name, value)
+		t.expireChunks(w, r, 0)
+		return nil
+	}
+
+	if chunks := (len(value) + t.cfg.ChunkSize - 1) / t.cfg.ChunkSize; chunks > maxCookieChunks {
+		return fmt.Errorf("%w: %d bytes need %d chunks, at most %d are allowed",
+			ErrCookieTooLarge, len(value), chunks, maxCookieChunks)
+	}
+
+	n := 0
+	for ; len(value) > 0; n++ {
+		size := min(t.cfg.ChunkSize, len(value))
+		set(t.chunkName(n), value[:size])
+		value = value[size:]
+	}
+
+	if _, err := r.Cookie(t.cfg.Name); err == nil {
+		t.expireCookie(w, t.cfg.Name)
+	}
+	t.expireChunks(w, r, n)
+	return nil
+}
+
+// Read returns the cookie value, joining chunk cookies if the value was
+// split.
+func (t *cookieTransport) Read(r *http.Request) (string, error) {
+	cookie, err := r.Cookie(t.cfg.Name)
+	if err == nil {
+		if cookie.Value == "" {
+			return "", ErrInvalidCookie
+		}
+		return cookie.Value, nil
+	}
+	if !errors.Is(err, http.ErrNoCookie) {
+		return "", ErrInvalidCookie
+	}
+
+	var sb strings.Builder
+	for i := 0; ; i++ {
+		chunk, err := r.Cookie(t.chunkName(i))
+		if err != nil {
+			if i == 0 {
+				return "", ErrNoCookie
+			}
+			break
+		}
+		if i == maxCookieChunks || chunk.Value == "" {
+			return "", ErrInvalidCookie
+		}
+		sb.WriteString(chunk.Value)
+	}
+
+	return sb.String(), nil
+}
+
+func (t *cookieTransport) Clear(w http.ResponseWriter, r *http.Request) {
+	t.expireCookie(w, t.cfg.Name)
+	t.expireChunks(w, r, 0)
+}
+
+func (t *cookieTransport) expireChunks(w http.ResponseWriter, r *http.Request, from int) {
+	for i := from; i <= maxCookieChunks; i++ {
+		if _, err := r.Cookie(t.chunkName(i)); err != nil {
+			break
+		}
+		t.expireCookie(w, t.chunkName(i))
+	}
+}
+
+func (t *cookieTransport) expireCookie(w http.ResponseWriter, name string) {
+	c := t.newCookie(name, "")
+	c.MaxAge = -1
+	c.Expires = time.Unix(0, 0)
+	http.SetCookie(w, c)
+}
@@ -0,0 +1,211 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCookieTransport(chunkSize int) Transport {
+	return NewCookieTransport(CookieConfig{
+		Name:      "sid",
+		Path:      "/",
+		MaxAge:    time.Hour,
+		ChunkSize: chunkSize,
+	})
+}
+
+func requestWith(cookies ...*http.Cookie) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+func TestCookieTransport_Write(t *testing.T) {
+	t.Run("should write a single cookie when the value fits", func(t *testing.T) {
+		ct := newTestCookieTransport(10)
+		w := httptest.NewRecorder()
+
+		ct.Write(w, requestWith(), "short", time.Now().Add(time.Hour))
+
+		cookies := w.Result().Cookies()
+		require.Len(t, cookies, 1)
+		assert.Equal(t, "sid", cookies[0].Name)
+		assert.Equal(t, "short", cookies[0].Value)
+	})
+
+	t.Run("should split long values into chunks", func(t *testing.T) {
+		ct := newTestCookieTransport(4)
+		w := httptest.NewRecorder()
+
+		ct.Write(w, requestWith(), "abcdefghij", time.Now().Add(time.Hour))
+
+		cookies := w.Result().Cookies()
+		require.Len(t, cookies, 3)
+		assert.Equal(t, "sid.0", cookies[0].Name)
+		assert.Equal(t, "abcd", cookies[0].Value)
+		assert.Equal(t, "sid.1", cookies[1].Name)
+		assert.Equal(t, "efgh", cookies[1].Value)
+		assert.Equal(t, "sid.2", cookies[2].Name)
+		assert.Equal(t, "ij", cookies[2].Value)
+	})
+
+	t.Run("should expire stale chunks from a longer value", func(t *testing.T) {
+		ct := newTestCookieTransport(4)
+		w := httptest.NewRecorder()
+		r := requestWith(
+			&http.Cookie{Name: "sid.0", Value: "abcd"},
+			&http.Cookie{Name: "sid.1", Value: "efgh"},
+			&http.Cookie{Name: "sid.2", Value: "ij"},
+		)
+
+		ct.Write(w, r, "abcdef", time.Now().Add(time.Hour))
+
+		cookies := w.Result().Cookies()
+		require.Len(t, cookies, 3)
+		assert.Equal(t, "sid.0", cookies[0].Name)
+		assert.Equal(t, "sid.1", cookies[1].Name)
+		assert.Equal(t, "sid.2", cookies[2].Name)
+		assert.Equal(t, -1, cookies[2].MaxAge)
+	})
+
+	t.Run("should expire chunks when the value fits again", func(t *testing.T) {
+		ct := newTestCookieTransport(4)
+		w := httptest.NewRecorder()
+		r := requestWith(
+			&http.Cookie{Name: "sid.0", Value: "abcd"},
+			&http.Cookie{Name: "sid.1", Value: "ef"},
+		)
+
+		ct.Write(w, r, "abc", time.Now().Add(time.Hour))
+
+		cookies := w.Result().Cookies()
+		require.Len(t, cookies, 3)
+		assert.Equal(t, "sid", cookies[0].Name)
+		assert.Equal(t, -1, cookies[1].MaxAge)
+		assert.Equal(t, -1, cookies[2].MaxAge)
+	})
+
+	t.Run("should expire the single cookie when switching to chunks", func(t *testing.T) {
+		ct := newTestCookieTransport(4)
+		w := httptest.NewRecorder()
+
+		ct.Write(w, requestWith(&http.Cookie{Name: "sid", Value: "abc"}), "abcdef", time.Now().Add(time.Hour))
+
+		cookies := w.Result().Cookies()
+		require.Len(t, cookies, 3)
+		assert.Equal(t, "sid", cookies[2].Name)
+		assert.Equal(t, -1, cookies[2].MaxAge)
+	})
+
+	t.Run("should not chunk when disabled", func(t *testing.T) {
+		ct := newTestCookieTransport(0)
+		w := httptest.NewRecorder()
+
+		ct.Write(w, requestWith(), strings.Repeat("a", 5000), time.Now().Add(time.Hour))
+
+		cookies := w.Result().Cookies()
+		require.Len(t, cookies, 1)
+		assert.Equal(t, "sid", cookies[0].Name)
+	})
+
+	t.Run("should reject values that need too many chunks", func(t *testing.T) {
+		ct := newTestCookieTransport(4)
+		w := httptest.NewRecorder()
+
+		err := ct.Write(w, requestWith(), strings.Repeat("a", 4*maxCookieChunks+1), time.Now().Add(time.Hour))
+		assert.ErrorIs(t, err, ErrCookieTooLarge)
+		assert.Empty(t, w.Result().Cookies())
+
+		w = httptest.NewRecorder()
+		require.NoError(t, ct.Write(w, requestWith(), strings.Repeat("a", 4*maxCookieChunks), time.Now().Add(time.Hour)))
+		assert.Len(t, w.Result().Cookies(), maxCookieChunks)
+	})
+}
+
+func TestCookieTransport_Read(t *testing.T) {
+	t.Run("should read a single cookie", func(t *testing.T) {
+		ct := newTestCookieTransport(4)
+
+		value, err := ct.Read(requestWith(&http.Cookie{Name: "sid", Value: "abc"}))
+		require.NoError(t, err)
+		assert.Equal(t, "abc", value)
+	})
+
+	t.Run("should join chunks", func(t *testing.T) {
+		ct := newTestCookieTransport(4)
+
+		value, err := ct.Read(requestWith(
+			&http.Cookie{Name: "sid.0", Value: "abcd"},
+			&http.Cookie{Name: "sid.1", Value: "efgh"},
+			&http.Cookie{Name: "sid.2", Value: "ij"},
+		))
+		require.NoError(t, err)
+		assert.Equal(t, "abcdefghij", value)
+	})
+
+	t.Run("should return ErrNoCookie without cookies", func(t *testing.T) {
+		ct := newTestCookieTransport(4)
+
+		_, err := ct.Read(requestWith())
+		assert.ErrorIs(t, err, ErrNoCookie)
+	})
+
+	t.Run("should reject empty values", func(t *testing.T) {
+		ct := newTestCookieTransport(4)
+
+		_, err := ct.Read(requestWith(&http.Cookie{Name: "sid", Value: ""}))
+		assert.ErrorIs(t, err, ErrInvalidCookie)
+	})
+}
+
+func TestCookieTransport_Clear(t *testing.T) {
+	t.Run("should expire the cookie and every chunk", func(t *testing.T) {
+		ct := newTestCookieTransport(4)
+		w := httptest.NewRecorder()
+
+		ct.Clear(w, requestWith(
+			&http.Cookie{Name: "sid.0", Value: "abcd"},
+			&http.Cookie{Name: "sid.1", Value: "ef"},
+		))
+
+		cookies := w.Result().Cookies()
+		require.Len(t, cookies, 3)
+		for _, c := range cookies {
+			assert.Equal(t, -1, c.MaxAge)
+		}
+	})
+}
+
+func TestMiddleware_ChunkedCookieStore(t *testing.T) {
+	t.Run("should round trip sessions larger than one cookie", func(t *testing.T) {
+		store := NewCookieStore(NewKeyring(Key{ID: "k1", Secret: []byte("secret")}), 0)
+
+		var loaded *Session
+		h := newHandler(store, func(w http.ResponseWriter, r *http.Request) {
+			loaded = GetOrCreate(r.Context(), time.Hour)
+			if _, ok := loaded.Get("blob"); !ok {
+				loaded.Set("blob", randomHex(4096))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+
+		w := serve(t, h)
+		cookies := w.Result().Cookies()
+		require.Greater(t, len(cookies), 1)
+		blob, _ := loaded.Get("blob")
+		id := loaded.ID
+
+		serve(t, h, cookies...)
+		assert.Equal(t, id, loaded.ID)
+		reloaded, _ := loaded.Get("blob")
+		assert.Equal(t, blob, reloaded)
+	})
+}
@@ -0,0 +1,133 @@
+package session
+
+import (
+	"reflect"
+	"time"
+)
+
+// copyData deep-copies session values so that nothing reachable from the
+// result is shared with data.
+func copyData(data map[string]any) map[string]any {
+	if data == nil {
+		return nil
+	}
+
+	c := copier{seen: make(map[copyKey]reflect.Value)}
+	out := make(map[string]any, len(data))
+	for k, v := range data {
+		out[k] = c.copyAny(v)
+	}
+	return out
+}
+
+// copyKey identifies an already copied pointer, map or slice, so shared
+// references stay shared in the copy and cycles terminate.
+type copyKey struct {
+	typ reflect.Type
+	ptr uintptr
+	len int
+}
+
+type copier struct {
+	seen map[copyKey]reflect.Value
+}
+
+func (c copier) copyAny(v any) any {
+	switch v := v.(type) {
+	case nil, string, bool, int, int8, int16, int32, int64,
+		uint, uint8, uint16, uint32, uint64, float32, float64, time.Time, time.Duration:
+		return v
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, e := range v {
+			out[k] = c.copyAny(e)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, e := range v {
+			out[i] = c.copyAny(e)
+		}
+		return out
+	}
+
+	return c.copyValue(reflect.ValueOf(v)).Interface()
+}
+
+// copyValue copies v recursively. Unexported struct fields are copied by
+// assignment since reflection cannot set them; channels and functions are
+// kept as they are.
+func (c copier) copyValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		key := copyKey{typ: v.Type(), ptr: v.Pointer()}
+		if out, ok := c.seen[key]; ok {
+			return out
+		}
+		out := reflect.New(v.Type().Elem())
+		c.seen[key] = out
+		out.Elem().Set(c.copyValue(v.Elem()))
+		return out
+
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		key := copyKey{typ: v.Type(), ptr: v.Pointer()}
+		if out, ok := c.seen[key]; ok {
+			return out
+		}
+		out := reflect.MakeMapWithSize(v.Type(), v.Len())
+		c.seen[key] = out
+		iter := v.MapRange()
+		for iter.Next() {
+			out.SetMapIndex(c.copyValue(iter.Key()), c.copyValue(iter.Value()))
+		}
+		return out
+
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		key := copyKey{typ: v.Type(), ptr: v.Pointer(), len: v.Len()}
+		if out, ok := c.seen[key]; ok {
+			return out
+		}
+		out := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		c.seen[key] = out
+		for i := range v.Len() {
+			out.Index(i).Set(c.copyValue(v.Index(i)))
+		}
+		return out
+
+	case reflect.Array:
+		out := reflect.New(v.Type()).Elem()
+		for i := range v.Len() {
+			out.Index(i).Set(c.copyValue(v.Index(i)))
+		}
+		return out
+
+	case reflect.Struct:
+		out := reflect.New(v.Type()).Elem()
+		out.Set(v)
+		for i := range v.NumField() {
+			if field := out.Field(i); field.CanSet() {
+				field.Set(c.copyValue(v.Field(i)))
+			}
+		}
+		return out
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.New(v.Type()).Elem()
+		out.Set(c.copyValue(v.Elem()))
+		return out
+	}
+
+	return v
+}
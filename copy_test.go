@@ -0,0 +1,96 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyData(t *testing.T) {
+	type address struct {
+		City string
+		Tags []string
+	}
+
+	type profile struct {
+		Name    string
+		Address *address
+		Scores  map[string]int
+		secret  []int
+	}
+
+	t.Run("should keep nil as nil", func(t *testing.T) {
+		assert.Nil(t, copyData(nil))
+	})
+
+	t.Run("should copy nested maps and slices", func(t *testing.T) {
+		original := map[string]any{
+			"list":  []any{"a", map[string]any{"b": 1}},
+			"ints":  []int{1, 2},
+			"inner": map[string]any{"k": []string{"v"}},
+			"array": [2][]int{{1}, {2}},
+			"when":  time.Unix(0, 0),
+		}
+
+		cpy := copyData(original)
+		require.Equal(t, original, cpy)
+
+		cpy["list"].([]any)[1].(map[string]any)["b"] = 2
+		cpy["ints"].([]int)[0] = 9
+		cpy["inner"].(map[string]any)["k"].([]string)[0] = "w"
+		cpy["array"].([2][]int)[0][0] = 9
+
+		assert.Equal(t, 1, original["list"].([]any)[1].(map[string]any)["b"])
+		assert.Equal(t, []int{1, 2}, original["ints"])
+		assert.Equal(t, []string{"v"}, original["inner"].(map[string]any)["k"])
+		assert.Equal(t, [2][]int{{1}, {2}}, original["array"])
+	})
+
+	t.Run("should copy pointers and exported struct fields", func(t *testing.T) {
+		p := &profile{
+			Name:    "ana",
+			Address: &address{City: "Recife", Tags: []string{"home"}},
+			Scores:  map[string]int{"go": 1},
+			secret:  []int{1},
+		}
+
+		cpy := copyData(map[string]any{"profile": p, "value": *p})
+		cp := cpy["profile"].(*profile)
+		cv := cpy["value"].(profile)
+
+		require.NotSame(t, p, cp)
+		require.NotSame(t, p.Address, cp.Address)
+		assert.Equal(t, *p, *cp)
+
+		cp.Address.Tags[0] = "work"
+		cp.Scores["go"] = 2
+		cv.Address.City = "Olinda"
+
+		assert.Equal(t, []string{"home"}, p.Address.Tags)
+		assert.Equal(t, 1, p.Scores["go"])
+		assert.Equal(t, "Recife", p.Address.City)
+	})
+
+	t.Run("should preserve shared references and cycles", func(t *testing.T) {
+		type node struct {
+			Next *node
+		}
+
+		n := &node{}
+		n.Next = n
+		shared := []int{1}
+
+		cpy := copyData(map[string]any{"node": n, "a": map[string][]int{"x": shared, "y": shared}})
+
+		cn := cpy["node"].(*node)
+		assert.NotSame(t, n, cn)
+		assert.Same(t, cn, cn.Next)
+
+		a := cpy["a"].(map[string][]int)
+		a["x"][0] = 2
+		assert.Equal(t, 2, a["y"][0])
+		assert.Equal(t, 1, shared[0])
+	})
+}
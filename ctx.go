@@ -10,6 +10,7 @@ type contextKey string
 const (
 	sessionContextKey contextKey = "session"
 	storeContextKey   contextKey = "store"
+	lockScopeKey      contextKey = "lock_scope"
 )
 
 func GetOrCreate(ctx context.Context, ttl time.Duration) *Session {
@@ -23,6 +24,9 @@ func GetOrCreate(ctx context.Context, ttl time.Duration) *Session {
 		return sess
 	}
 	sess = NewSession(ttl)
+	if store, err := GetStore(ctx); err == nil {
+		sess.codec = storeCodec(store)
+	}
 	holder.set(sess)
 	return sess
 }
@@ -94,3 +98,14 @@ func MustGetStore(ctx context.Context) Store {
 	}
 	return store
 }
+
+// WithLockScope returns a context whose Lock calls share a LockScope. The
+// middleware gives each locked request one.
+func WithLockScope(ctx context.Context) context.Context {
+	return context.WithValue(ctx, lockScopeKey, &LockScope{})
+}
+
+func LockScopeFromContext(ctx context.Context) (*LockScope, bool) {
+	scope, ok := ctx.Value(lockScopeKey).(*LockScope)
+	return scope, ok
+}
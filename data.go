@@ -7,13 +7,37 @@ import (
 )
 
 type SessionData struct {
-	ID            string         `json:"id"`
-	Data          map[string]any `json:"data"`
-	CreatedAt     time.Time      `json:"created_at"`
-	ExpiresAt     time.Time      `json:"expires_at"`
-	UpdatedAt     time.Time      `json:"updated_at"`
-	Authenticated bool           `json:"authenticated"`
-	UserID        string         `json:"user_id"`
+	ID   string         `json:"id"`
+	Data map[string]any `json:"data"`
+	// CreatedAt is when the session was first issued.
+	CreatedAt time.Time `json:"created_at"`
+	// ExpiresAt is the sliding deadline moved forward by Renew. With an idle
+	// timeout configured it is the idle deadline.
+	ExpiresAt time.Time `json:"expires_at"`
+	// AbsoluteExpiresAt is the hard deadline derived from CreatedAt that
+	// Renew never extends past. The zero value means no absolute limit.
+	AbsoluteExpiresAt time.Time `json:"absolute_expires_at"`
+	// RotatedAt is when the ID was last regenerated. The zero value means
+	// the ID has not changed since CreatedAt.
+	RotatedAt     time.Time `json:"rotated_at,omitzero"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Authenticated bool      `json:"authenticated"`
+	UserID        string    `json:"user_id"`
+
+	// Client metadata, recorded when Options.TrackMetadata is enabled.
+	IP        string `json:"ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	// Device is a label parsed from UserAgent, e.g. "Firefox on Linux".
+	Device     string    `json:"device,omitempty"`
+	LastSeenAt time.Time `json:"last_seen_at,omitzero"`
+
+	// Version is incremented by every compare-and-set write, see
+	// VersionedStore.
+	Version int64 `json:"version,omitempty"`
+
+	// Fingerprint is the hash of the client characteristics the session is
+	// bound to, see Options.Binding.
+	Fingerprint string `json:"fingerprint,omitempty"`
 }
 
 func NewSessionData(ttl time.Duration) SessionData {
@@ -58,12 +82,38 @@ func (s *SessionData) Unauthenticate() {
 
 func (s *SessionData) Renew(ttl time.Duration) {
 	now := now()
-	s.ExpiresAt = now.Add(ttl)
+	s.ExpiresAt = s.capExpiry(now.Add(ttl))
 	s.UpdatedAt = now
 }
 
+// SetAbsoluteTimeout limits the session to timeout after CreatedAt,
+// pulling ExpiresAt in if it currently lies beyond that point.
+func (s *SessionData) SetAbsoluteTimeout(timeout time.Duration) {
+	s.AbsoluteExpiresAt = s.CreatedAt.Add(timeout)
+	s.ExpiresAt = s.capExpiry(s.ExpiresAt)
+}
+
 func (s *SessionData) IsExpired() bool {
-	return now().After(s.ExpiresAt)
+	return now().After(s.ExpiresAt) || s.IsAbsoluteExpired()
+}
+
+func (s *SessionData) IsAbsoluteExpired() bool {
+	return !s.AbsoluteExpiresAt.IsZero() && now().After(s.AbsoluteExpiresAt)
+}
+
+// idIssuedAt is when the current ID was issued.
+func (s *SessionData) idIssuedAt() time.Time {
+	if s.RotatedAt.IsZero() {
+		return s.CreatedAt
+	}
+	return s.RotatedAt
+}
+
+func (s *SessionData) capExpiry(t time.Time) time.Time {
+	if !s.AbsoluteExpiresAt.IsZero() && t.After(s.AbsoluteExpiresAt) {
+		return s.AbsoluteExpiresAt
+	}
+	return t
 }
 
 // generateID generates a cryptographically secure session ID.
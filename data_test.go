@@ -264,6 +264,41 @@ func TestSessionData_IsExpired(t *testing.T) {
 	})
 }
 
+func TestSessionData_AbsoluteTimeout(t *testing.T) {
+	t.Run("should not renew past the absolute deadline", func(t *testing.T) {
+		data := NewSessionData(time.Hour)
+		data.SetAbsoluteTimeout(90 * time.Minute)
+
+		assert.Equal(t, data.CreatedAt.Add(90*time.Minute), data.AbsoluteExpiresAt)
+
+		data.Renew(time.Hour * 24)
+		assert.Equal(t, data.AbsoluteExpiresAt, data.ExpiresAt)
+	})
+
+	t.Run("should pull expiry in to the absolute deadline", func(t *testing.T) {
+		data := NewSessionData(time.Hour * 24)
+		data.SetAbsoluteTimeout(time.Hour)
+
+		assert.Equal(t, data.CreatedAt.Add(time.Hour), data.ExpiresAt)
+	})
+
+	t.Run("should report absolute expiry", func(t *testing.T) {
+		now = func() time.Time { return time.Now().Add(-2 * time.Hour) }
+		data := NewSessionData(time.Hour * 24)
+		data.SetAbsoluteTimeout(time.Hour)
+		now = func() time.Time { return time.Now() }
+
+		assert.True(t, data.IsAbsoluteExpired())
+		assert.True(t, data.IsExpired())
+	})
+
+	t.Run("should not expire without an absolute deadline", func(t *testing.T) {
+		data := NewSessionData(time.Hour)
+
+		assert.False(t, data.IsAbsoluteExpired())
+	})
+}
+
 func TestSessionData_GenerateId(t *testing.T) {
 	t.Run("should generate id", func(t *testing.T) {
 		ids := make(map[string]bool)
@@ -1,14 +1,50 @@
 package session
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	ErrNoCookie         = errors.New("no cookie found")
+	ErrNoToken          = errors.New("no session token found")
 	ErrInvalidCookie    = errors.New("invalid session cookie")
 	ErrInvalidSignature = errors.New("invalid cookie signature")
+	ErrUnknownKeyID     = errors.New("unknown cookie signing key")
+	ErrCookieTampered   = errors.New("session cookie failed authentication")
+	ErrCookieExpired    = errors.New("session cookie expired")
+	ErrCookieTooLarge   = errors.New("session cookie too large")
 	ErrSessionNotFound  = errors.New("session not found")
 	ErrSessionExpired   = errors.New("session expired")
 
+	ErrSessionLimitExceeded     = errors.New("session limit exceeded")
+	ErrSessionBindingMismatch   = errors.New("session fingerprint mismatch")
+	ErrLockTimeout              = errors.New("timed out waiting for session lock")
+	ErrConcurrentModification   = errors.New("session was modified concurrently")
+	ErrPartialUpdateUnsupported = errors.New("stored session cannot be updated partially")
+
+	ErrKeyNotFound  = errors.New("session key not found")
+	ErrInvalidValue = errors.New("session value has an unexpected type")
+
+	ErrSessionIdleTimeout     = fmt.Errorf("%w: idle timeout reached", ErrSessionExpired)
+	ErrSessionAbsoluteTimeout = fmt.Errorf("%w: absolute lifetime reached", ErrSessionExpired)
+
 	ErrStoreNotFound = errors.New("store not found in context")
 	ErrStoreInvalid  = errors.New("invalid store in context")
 )
+
+// SessionLimitError is returned when a login is rejected because the user
+// already holds the maximum number of sessions. It matches
+// ErrSessionLimitExceeded with errors.Is.
+type SessionLimitError struct {
+	UserID string
+	Limit  int
+}
+
+func (e *SessionLimitError) Error() string {
+	return fmt.Sprintf("session limit exceeded: user %s already has %d active sessions", e.UserID, e.Limit)
+}
+
+func (e *SessionLimitError) Unwrap() error {
+	return ErrSessionLimitExceeded
+}
@@ -0,0 +1,106 @@
+package session
+
+const (
+	flashKey = "_flash"
+)
+
+// AddFlash queues a message under kind (e.g. "error", "success") until the
+// next Flashes call for that kind, which is usually on the request after a
+// redirect.
+func (s *Session) AddFlash(kind, msg string) *Session {
+	return s.addFlash(kind, msg)
+}
+
+// Flashes returns and clears the messages queued under kind.
+func (s *Session) Flashes(kind string) []string {
+	raw := s.takeFlashes(kind)
+
+	msgs := make([]string, 0, len(raw))
+	for _, v := range raw {
+		if msg, ok := v.(string); ok {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+// HasFlashes reports whether messages are queued under kind without
+// clearing them.
+func (s *Session) HasFlashes(kind string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.flashesLocked()[kind]) > 0
+}
+
+// AddFlashAs queues a structured payload under kind.
+func AddFlashAs[T any](s *Session, kind string, value T) *Session {
+	return s.addFlash(kind, value)
+}
+
+// FlashesAs returns and clears the payloads queued under kind, decoded into
+// T. The payloads are cleared even if one of them fails to decode.
+func FlashesAs[T any](s *Session, kind string) ([]T, error) {
+	raw := s.takeFlashes(kind)
+	codec := s.valueCodec()
+
+	values := make([]T, 0, len(raw))
+	for _, v := range raw {
+		value, err := convertValue[T](codec, v)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func (s *Session) addFlash(kind string, value any) *Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	flashes := s.flashesLocked()
+	flashes[kind] = append(flashes[kind], value)
+	s.setLocked(flashKey, flashes)
+	return s
+}
+
+func (s *Session) takeFlashes(kind string) []any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	flashes := s.flashesLocked()
+	taken, ok := flashes[kind]
+	if !ok {
+		return nil
+	}
+
+	delete(flashes, kind)
+	if len(flashes) == 0 {
+		s.deleteLocked(flashKey)
+	} else {
+		s.setLocked(flashKey, flashes)
+	}
+	return taken
+}
+
+// flashesLocked returns a copy of the queued flashes, accepting both the
+// in-memory shape and the generic shape a store decodes them into.
+func (s *Session) flashesLocked() map[string][]any {
+	flashes := make(map[string][]any)
+
+	switch raw := s.Data[flashKey].(type) {
+	case map[string][]any:
+		for kind, values := range raw {
+			flashes[kind] = append([]any(nil), values...)
+		}
+	case map[string]any:
+		for kind, values := range raw {
+			if list, ok := values.([]any); ok {
+				flashes[kind] = append([]any(nil), list...)
+			}
+		}
+	}
+
+	return flashes
+}
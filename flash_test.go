@@ -0,0 +1,129 @@
+package session
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func jsonRoundTrip(t *testing.T, s *Session) *Session {
+	t.Helper()
+
+	data, err := json.Marshal(s.GetSessionData())
+	require.NoError(t, err)
+
+	var sd SessionData
+	require.NoError(t, json.Unmarshal(data, &sd))
+	return NewSessionFromData(sd)
+}
+
+func TestSession_Flashes(t *testing.T) {
+	t.Run("should return and clear messages by kind", func(t *testing.T) {
+		s := NewSession(time.Hour)
+		s.AddFlash("error", "first").AddFlash("error", "second").AddFlash("info", "hello")
+
+		assert.True(t, s.HasFlashes("error"))
+		assert.Equal(t, []string{"first", "second"}, s.Flashes("error"))
+		assert.False(t, s.HasFlashes("error"))
+		assert.Empty(t, s.Flashes("error"))
+		assert.Equal(t, []string{"hello"}, s.Flashes("info"))
+
+		_, ok := s.Get(flashKey)
+		assert.False(t, ok)
+	})
+
+	t.Run("should mark the session modified when clearing", func(t *testing.T) {
+		s := NewSession(time.Hour)
+		s.AddFlash("info", "hello")
+		s.MarkClean()
+
+		s.Flashes("info")
+
+		assert.True(t, s.IsModified())
+	})
+
+	t.Run("should not mark the session modified without flashes", func(t *testing.T) {
+		s := NewSession(time.Hour)
+		s.MarkClean()
+
+		assert.Empty(t, s.Flashes("info"))
+		assert.False(t, s.IsModified())
+	})
+
+	t.Run("should read flashes after a store round trip", func(t *testing.T) {
+		s := NewSession(time.Hour)
+		s.AddFlash("info", "hello")
+
+		loaded := jsonRoundTrip(t, s)
+
+		assert.Equal(t, []string{"hello"}, loaded.Flashes("info"))
+	})
+}
+
+func TestFlashesAs(t *testing.T) {
+	type notice struct {
+		Title string `json:"title"`
+		Count int    `json:"count"`
+	}
+
+	t.Run("should return typed payloads", func(t *testing.T) {
+		s := NewSession(time.Hour)
+		AddFlashAs(s, "notice", notice{Title: "saved", Count: 2})
+
+		notices, err := FlashesAs[notice](s, "notice")
+		require.NoError(t, err)
+		assert.Equal(t, []notice{{Title: "saved", Count: 2}}, notices)
+	})
+
+	t.Run("should decode payloads after a store round trip", func(t *testing.T) {
+		s := NewSession(time.Hour)
+		AddFlashAs(s, "notice", notice{Title: "saved", Count: 2})
+
+		loaded := jsonRoundTrip(t, s)
+
+		notices, err := FlashesAs[notice](loaded, "notice")
+		require.NoError(t, err)
+		assert.Equal(t, []notice{{Title: "saved", Count: 2}}, notices)
+		assert.False(t, loaded.HasFlashes("notice"))
+	})
+
+	t.Run("should fail on payloads of another type", func(t *testing.T) {
+		s := NewSession(time.Hour)
+		s.AddFlash("notice", "plain text")
+
+		_, err := FlashesAs[notice](s, "notice")
+		assert.ErrorIs(t, err, ErrInvalidValue)
+	})
+}
+
+func TestMiddleware_Flashes(t *testing.T) {
+	t.Run("should survive exactly one redirect", func(t *testing.T) {
+		var got []string
+		h := newHandler(NewMemoryStore(), func(w http.ResponseWriter, r *http.Request) {
+			sess := GetOrCreate(r.Context(), time.Hour)
+			if r.URL.Path == "/save" {
+				sess.AddFlash("success", "saved")
+				http.Redirect(w, r, "/", http.StatusSeeOther)
+				return
+			}
+			got = sess.Flashes("success")
+			w.WriteHeader(http.StatusOK)
+		})
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/save", nil))
+		c := responseCookie(w, "sid")
+		require.NotNil(t, c)
+
+		serve(t, h, c)
+		assert.Equal(t, []string{"saved"}, got)
+
+		serve(t, h, c)
+		assert.Empty(t, got)
+	})
+}
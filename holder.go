@@ -1,7 +1,11 @@
 package session
 
+import "context"
+
 type holder struct {
 	session *Session
+	// flush persists a session the way the middleware does on commit.
+	flush func(ctx context.Context, s *Session) error
 }
 
 func (h *holder) get() *Session {
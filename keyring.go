@@ -0,0 +1,191 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+)
+
+const (
+	encryptionKeyInfo = "session cookie encryption"
+)
+
+// Key is a signing key identified by ID. The ID is embedded in every cookie
+// signed with the key so verification can pick the right key directly.
+//
+// A key with an empty ID produces the untagged "s:<id>.<sig>" format, which
+// is what Options.Secret has always produced.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// Keyring holds the active signing key plus any previous keys that are
+// still accepted during rotation.
+type Keyring struct {
+	active Key
+	keys   map[string]Key
+	aeads  map[string]cipher.AEAD
+}
+
+// NewKeyring creates a keyring that signs with active and still verifies
+// cookies signed with any of the previous keys.
+func NewKeyring(active Key, previous ...Key) *Keyring {
+	k := &Keyring{
+		active: active,
+		keys:   make(map[string]Key, len(previous)+1),
+		aeads:  make(map[string]cipher.AEAD, len(previous)+1),
+	}
+
+	for _, key := range append([]Key{active}, previous...) {
+		if len(key.Secret) == 0 {
+			panic("session: keyring key has an empty secret")
+		}
+		if strings.ContainsAny(key.ID, ":.") {
+			panic("session: keyring key id must not contain ':' or '.'")
+		}
+		if _, ok := k.keys[key.ID]; ok {
+			panic("session: duplicate keyring key id " + key.ID)
+		}
+		k.keys[key.ID] = key
+		k.aeads[key.ID] = newAEAD(key.Secret)
+	}
+
+	return k
+}
+
+func (k *Keyring) Active() Key {
+	return k.active
+}
+
+func (k *Keyring) Lookup(id string) (Key, bool) {
+	key, ok := k.keys[id]
+	return key, ok
+}
+
+// Sign signs the session id with the active key.
+func (k *Keyring) Sign(id string) string {
+	if k.active.ID == "" {
+		return encodeSessionId(id, string(k.active.Secret))
+	}
+
+	return "s:" + k.active.ID + ":" + id + "." + sign(id, k.active.Secret)
+}
+
+// Verify checks a value produced by Sign with the key named in the value
+// and returns the session id. Untagged values are checked against the key
+// with an empty ID.
+func (k *Keyring) Verify(signedValue string) (string, error) {
+	if !strings.HasPrefix(signedValue, "s:") || len(signedValue) < 2 {
+		return "", ErrInvalidSignature
+	}
+
+	value := signedValue[2:]
+
+	keyID := ""
+	if i := strings.IndexByte(value, ':'); i >= 0 {
+		keyID = value[:i]
+		value = value[i+1:]
+	}
+
+	parts := strings.Split(value, ".")
+	if len(parts) != 2 {
+		return "", ErrInvalidSignature
+	}
+
+	key, ok := k.keys[keyID]
+	if !ok {
+		return "", ErrUnknownKeyID
+	}
+
+	sessionID := parts[0]
+	receivedSig := parts[1]
+	expectedSig := sign(sessionID, key.Secret)
+
+	if !hmac.Equal([]byte(receivedSig), []byte(expectedSig)) {
+		return "", ErrInvalidSignature
+	}
+
+	return sessionID, nil
+}
+
+func sign(value string, secret []byte) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Seal encrypts and authenticates plaintext with the active key using
+// AES-256-GCM. The result has the form "e:<kid>:<payload>".
+func (k *Keyring) Seal(plaintext []byte) string {
+	aead := k.aeads[k.active.ID]
+	prefix := "e:" + k.active.ID + ":"
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	_, _ = rand.Read(nonce)
+	sealed := aead.Seal(nonce, nonce, plaintext, []byte(prefix))
+
+	return prefix + base64.RawURLEncoding.EncodeToString(sealed)
+}
+
+// Open reverses Seal using the key named in the value.
+func (k *Keyring) Open(value string) ([]byte, error) {
+	if !strings.HasPrefix(value, "e:") {
+		return nil, ErrInvalidCookie
+	}
+
+	i := strings.IndexByte(value[2:], ':')
+	if i < 0 {
+		return nil, ErrInvalidCookie
+	}
+	keyID := value[2 : 2+i]
+	prefix := value[:2+i+1]
+
+	aead, ok := k.aeads[keyID]
+	if !ok {
+		return nil, ErrUnknownKeyID
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(value[len(prefix):])
+	if err != nil {
+		return nil, ErrCookieTampered
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrCookieTampered
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(prefix))
+	if err != nil {
+		return nil, ErrCookieTampered
+	}
+
+	return plaintext, nil
+}
+
+// newAEAD derives a dedicated encryption key from the signing secret so the
+// same keyring can both sign and encrypt.
+func newAEAD(secret []byte) cipher.AEAD {
+	encKey, err := hkdf.Key(sha256.New, secret, nil, encryptionKeyInfo, 32)
+	if err != nil {
+		panic("session: derive encryption key: " + err.Error())
+	}
+
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		panic("session: create cipher: " + err.Error())
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic("session: create gcm: " + err.Error())
+	}
+
+	return aead
+}
@@ -0,0 +1,150 @@
+package session
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyring_SignAndVerify(t *testing.T) {
+	t.Run("should keep the legacy format for keys without id", func(t *testing.T) {
+		k := NewKeyring(Key{Secret: []byte("secret")})
+
+		signed := k.Sign("abc")
+		assert.Equal(t, encodeSessionId("abc", "secret"), signed)
+
+		id, err := k.Verify(signed)
+		require.NoError(t, err)
+		assert.Equal(t, "abc", id)
+	})
+
+	t.Run("should tag cookies with the active key id", func(t *testing.T) {
+		k := NewKeyring(Key{ID: "k2", Secret: []byte("new")})
+
+		signed := k.Sign("abc")
+		assert.Equal(t, "s:k2:abc."+sign("abc", []byte("new")), signed)
+
+		id, err := k.Verify(signed)
+		require.NoError(t, err)
+		assert.Equal(t, "abc", id)
+	})
+
+	t.Run("should verify cookies signed with previous keys", func(t *testing.T) {
+		old := NewKeyring(Key{ID: "k1", Secret: []byte("old")})
+		legacy := NewKeyring(Key{Secret: []byte("legacy")})
+		k := NewKeyring(
+			Key{ID: "k2", Secret: []byte("new")},
+			Key{ID: "k1", Secret: []byte("old")},
+			Key{Secret: []byte("legacy")},
+		)
+
+		id, err := k.Verify(old.Sign("abc"))
+		require.NoError(t, err)
+		assert.Equal(t, "abc", id)
+
+		id, err = k.Verify(legacy.Sign("def"))
+		require.NoError(t, err)
+		assert.Equal(t, "def", id)
+	})
+
+	t.Run("should reject unknown key ids", func(t *testing.T) {
+		other := NewKeyring(Key{ID: "k9", Secret: []byte("other")})
+		k := NewKeyring(Key{ID: "k2", Secret: []byte("new")})
+
+		_, err := k.Verify(other.Sign("abc"))
+		assert.ErrorIs(t, err, ErrUnknownKeyID)
+	})
+
+	t.Run("should reject a signature made with a different secret", func(t *testing.T) {
+		k := NewKeyring(Key{ID: "k1", Secret: []byte("new")})
+		forged := "s:k1:abc." + sign("abc", []byte("guess"))
+
+		_, err := k.Verify(forged)
+		assert.ErrorIs(t, err, ErrInvalidSignature)
+	})
+
+	t.Run("should reject malformed values", func(t *testing.T) {
+		k := NewKeyring(Key{Secret: []byte("secret")})
+
+		for _, v := range []string{"", "s:", "abc.def", "s:abc", "s:a.b.c"} {
+			_, err := k.Verify(v)
+			assert.Error(t, err, v)
+		}
+	})
+}
+
+func TestNewKeyring(t *testing.T) {
+	t.Run("should panic on invalid keys", func(t *testing.T) {
+		assert.Panics(t, func() { NewKeyring(Key{ID: "k1"}) })
+		assert.Panics(t, func() { NewKeyring(Key{ID: "k:1", Secret: []byte("s")}) })
+		assert.Panics(t, func() {
+			NewKeyring(Key{ID: "k1", Secret: []byte("a")}, Key{ID: "k1", Secret: []byte("b")})
+		})
+	})
+
+	t.Run("should expose active and previous keys", func(t *testing.T) {
+		k := NewKeyring(Key{ID: "k2", Secret: []byte("new")}, Key{ID: "k1", Secret: []byte("old")})
+
+		assert.Equal(t, "k2", k.Active().ID)
+		key, ok := k.Lookup("k1")
+		assert.True(t, ok)
+		assert.Equal(t, []byte("old"), key.Secret)
+		_, ok = k.Lookup("k3")
+		assert.False(t, ok)
+	})
+}
+
+func TestKeyring_SealAndOpen(t *testing.T) {
+	t.Run("should round trip with the active key", func(t *testing.T) {
+		k := NewKeyring(Key{ID: "k1", Secret: []byte("secret")})
+
+		sealed := k.Seal([]byte("payload"))
+		assert.True(t, strings.HasPrefix(sealed, "e:k1:"))
+		assert.NotContains(t, sealed, "payload")
+
+		plaintext, err := k.Open(sealed)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("payload"), plaintext)
+	})
+
+	t.Run("should open values sealed with previous keys", func(t *testing.T) {
+		old := NewKeyring(Key{ID: "k1", Secret: []byte("old")})
+		k := NewKeyring(Key{ID: "k2", Secret: []byte("new")}, Key{ID: "k1", Secret: []byte("old")})
+
+		plaintext, err := k.Open(old.Seal([]byte("payload")))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("payload"), plaintext)
+	})
+
+	t.Run("should reject tampered values", func(t *testing.T) {
+		k := NewKeyring(Key{ID: "k1", Secret: []byte("secret")})
+		sealed := []byte(k.Seal([]byte("payload")))
+		// The last base64 character may carry padding bits, so flip one
+		// inside the ciphertext.
+		i := len(sealed) - 5
+		if sealed[i] == 'A' {
+			sealed[i] = 'B'
+		} else {
+			sealed[i] = 'A'
+		}
+
+		_, err := k.Open(string(sealed))
+		assert.ErrorIs(t, err, ErrCookieTampered)
+
+		_, err = k.Open("e:k1:!!!")
+		assert.ErrorIs(t, err, ErrCookieTampered)
+	})
+
+	t.Run("should reject values moved to another key id", func(t *testing.T) {
+		k := NewKeyring(Key{ID: "k2", Secret: []byte("new")}, Key{ID: "k1", Secret: []byte("old")})
+		sealed := k.Seal([]byte("payload"))
+
+		_, err := k.Open("e:k1:" + strings.TrimPrefix(sealed, "e:k2:"))
+		assert.ErrorIs(t, err, ErrCookieTampered)
+
+		_, err = k.Open("e:k9:" + strings.TrimPrefix(sealed, "e:k2:"))
+		assert.ErrorIs(t, err, ErrUnknownKeyID)
+	})
+}
@@ -0,0 +1,29 @@
+package session
+
+import (
+	"context"
+	"fmt"
+)
+
+// SessionLimitPolicy decides what happens when a user logs in while already
+// holding limit or more other sessions. active lists those sessions, oldest
+// first. Returning an error rejects the login and the new session is not
+// saved.
+type SessionLimitPolicy func(ctx context.Context, store Store, current SessionData, active []SessionData, limit int) error
+
+// EvictOldest deletes the user's oldest sessions to make room for the new
+// one. It is the default policy.
+func EvictOldest(ctx context.Context, store Store, current SessionData, active []SessionData, limit int) error {
+	for _, sess := range active[:len(active)-limit+1] {
+		if err := store.Delete(ctx, sess.ID); err != nil {
+			return fmt.Errorf("evict session: %w", err)
+		}
+	}
+	return nil
+}
+
+// RejectNewLogin refuses the login with a *SessionLimitError and keeps the
+// existing sessions.
+func RejectNewLogin(ctx context.Context, store Store, current SessionData, active []SessionData, limit int) error {
+	return &SessionLimitError{UserID: current.UserID, Limit: limit}
+}
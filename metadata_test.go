@@ -0,0 +1,72 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := parseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		realIP     string
+		expected   string
+	}{
+		{"direct client", "203.0.113.7:1234", "", "", "203.0.113.7"},
+		{"untrusted proxy is ignored", "203.0.113.7:1234", "198.51.100.1", "", "203.0.113.7"},
+		{"trusted proxy", "10.1.2.3:1234", "198.51.100.1", "", "198.51.100.1"},
+		{"skips trusted hops from the right", "10.1.2.3:1234", "198.51.100.1, 192.168.1.1, 10.0.0.5", "", "198.51.100.1"},
+		{"spoofed leftmost entry", "10.1.2.3:1234", "1.2.3.4, 198.51.100.1", "", "198.51.100.1"},
+		{"falls back to X-Real-IP", "10.1.2.3:1234", "", "198.51.100.9", "198.51.100.9"},
+		{"ipv6", "[2001:db8::1]:1234", "", "", "2001:db8::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			assert.Equal(t, tt.expected, clientIP(r, trusted))
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	t.Run("should panic on invalid input", func(t *testing.T) {
+		assert.Panics(t, func() { parseTrustedProxies([]string{"not-an-ip"}) })
+	})
+}
+
+func TestDeviceLabel(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		expected  string
+	}{
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36", "Chrome on Windows"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.0.0", "Edge on Windows"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1", "Safari on iOS"},
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0", "Firefox on Linux"},
+		{"Mozilla/5.0 (Linux; Android 14) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36", "Chrome on Android"},
+		{"curl/8.4.0", "curl"},
+		{"something else", "Unknown"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected, func(t *testing.T) {
+			assert.Equal(t, tt.expected, deviceLabel(tt.userAgent))
+		})
+	}
+}
@@ -2,12 +2,12 @@ package session
 
 import (
 	"context"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/base64"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/netip"
+	"slices"
 	"strings"
 	"time"
 )
@@ -15,26 +15,62 @@ import (
 type Middleware struct {
 	log               Logger
 	store             Store
-	cookieName        string
-	secret            string
+	codec             Codec
+	transport         Transport
+	keyring           *Keyring
+	encryptCookie     bool
 	ttl               time.Duration
-	httpOnly          bool
-	secure            bool
-	sameSite          http.SameSite
+	idleTimeout       time.Duration
+	absoluteTimeout   time.Duration
 	saveUninitialized bool
 	autoRenew         bool
-	path              string
+	renewThreshold    float64
 	errorHandler      ErrorHandler
+
+	maxSessionsPerUser int
+	sessionLimitPolicy SessionLimitPolicy
+
+	trackMetadata  bool
+	trustedProxies []netip.Prefix
+
+	binding           BindingMode
+	fingerprint       FingerprintFunc
+	onBindingMismatch BindingMismatchHook
+
+	regenerateOnAuth bool
+	rotationInterval time.Duration
+	regenerateGrace  time.Duration
+
+	locker      Locker
+	lockTimeout time.Duration
+	lockBypass  func(r *http.Request) bool
+
+	versioned          VersionedStore
+	concurrencyRetries int
 }
 
 func (m *Middleware) Handler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.locker != nil {
+			// The user lock taken on a login shares the scope of the
+			// session lock.
+			r = r.WithContext(WithLockScope(r.Context()))
+		}
+
+		unlock, err := m.lock(r)
+		if err != nil {
+			m.onError(w, r, err)
+			return
+		}
+		defer unlock()
+
 		session, err := m.loadSession(r)
 		if err != nil {
 			m.log.Warnf("session id resolve failed: %v", err)
 		}
 		if session == nil && m.saveUninitialized {
 			session = NewSession(m.ttl)
+			session.codec = m.codec
 			m.log.Debugf("Anonymous session created: sessionID=%s",
 				session.ID[:8]+"...",
 			)
@@ -47,6 +83,9 @@ func (m *Middleware) Handler(next http.Handler) http.Handler {
 		ctx = withStoreContext(ctx, m.store)
 
 		rWithCtx := r.WithContext(ctx)
+		holder.flush = func(ctx context.Context, s *Session) error {
+			return m.flush(ctx, rWithCtx, s)
+		}
 
 		ww := m.writer(w, rWithCtx)
 		next.ServeHTTP(ww, rWithCtx)
@@ -55,37 +94,121 @@ func (m *Middleware) Handler(next http.Handler) http.Handler {
 
 func Handler(opts ...func(*Options)) func(handler http.Handler) http.Handler {
 	opt := &Options{
-		Logger:            &defaultLogger{},
-		Store:             NewMemoryStore(),
-		SaveUninitialized: false,
-		AutoRenew:         false,
-		Secret:            "secret",
-		CookieName:        "sid",
-		Path:              "/",
-		HTTPOnly:          true,
-		Secure:            false,
-		SameSite:          http.SameSiteNoneMode,
-		TTL:               time.Hour * 1,
-		ErrorHandler:      nil,
+		Logger:             &defaultLogger{},
+		SaveUninitialized:  false,
+		AutoRenew:          false,
+		Secret:             "secret",
+		CookieName:         "sid",
+		Path:               "/",
+		HTTPOnly:           true,
+		Secure:             false,
+		SameSite:           http.SameSiteNoneMode,
+		TTL:                time.Hour * 1,
+		CookieChunkSize:    defaultCookieChunkSize,
+		RegenerateGrace:    defaultRegenerateGrace,
+		LockTimeout:        defaultLockTimeout,
+		ConcurrencyRetries: defaultConcurrencyRetries,
+		ErrorHandler:       nil,
 	}
 
 	for _, o := range opts {
 		o(opt)
 	}
 
+	// Only start a memory store, and its janitor, when no store was given.
+	if opt.Store == nil {
+		opt.Store = NewMemoryStore()
+	}
+
+	if opt.Keyring == nil {
+		opt.Keyring = NewKeyring(Key{Secret: []byte(opt.Secret)})
+	}
+
+	if opt.Transport == nil {
+		opt.Transport = NewCookieTransport(CookieConfig{
+			Name:      opt.CookieName,
+			Path:      opt.Path,
+			HTTPOnly:  opt.HTTPOnly,
+			Secure:    opt.Secure,
+			SameSite:  opt.SameSite,
+			MaxAge:    opt.TTL,
+			ChunkSize: opt.CookieChunkSize,
+		})
+	}
+
+	if opt.MaxSessionsPerUser > 0 {
+		if _, ok := opt.Store.(UserIndex); !ok {
+			panic("session: MaxSessionsPerUser requires a store that implements UserIndex")
+		}
+	}
+
+	if opt.SessionLimitPolicy == nil {
+		opt.SessionLimitPolicy = EvictOldest
+	}
+
+	// A zero timeout would fail every lock at once, which is what
+	// HandlerWithOptions passes when LockTimeout is left unset.
+	if opt.LockTimeout <= 0 {
+		opt.LockTimeout = defaultLockTimeout
+	}
+
+	var locker Locker
+	if opt.LockSessions {
+		l, ok := opt.Store.(Locker)
+		if !ok {
+			panic("session: LockSessions requires a store that implements Locker")
+		}
+		locker = l
+	}
+
+	var versioned VersionedStore
+	if opt.OptimisticConcurrency {
+		v, ok := opt.Store.(VersionedStore)
+		if !ok {
+			panic("session: OptimisticConcurrency requires a store that implements VersionedStore")
+		}
+		versioned = v
+	}
+
+	if opt.Fingerprint == nil {
+		opt.Fingerprint = UserAgentFingerprint
+	}
+
 	m := &Middleware{
 		log:               opt.Logger,
 		store:             opt.Store,
-		cookieName:        opt.CookieName,
-		secret:            opt.Secret,
+		codec:             storeCodec(opt.Store),
+		transport:         opt.Transport,
+		keyring:           opt.Keyring,
+		encryptCookie:     opt.EncryptCookie,
 		ttl:               opt.TTL,
-		httpOnly:          opt.HTTPOnly,
-		secure:            opt.Secure,
-		sameSite:          opt.SameSite,
+		idleTimeout:       opt.IdleTimeout,
+		absoluteTimeout:   opt.AbsoluteTimeout,
 		saveUninitialized: opt.SaveUninitialized,
 		autoRenew:         opt.AutoRenew,
-		path:              opt.Path,
+		renewThreshold:    opt.RenewThreshold,
 		errorHandler:      opt.ErrorHandler,
+
+		maxSessionsPerUser: opt.MaxSessionsPerUser,
+		sessionLimitPolicy: opt.SessionLimitPolicy,
+
+		trackMetadata:  opt.TrackMetadata,
+		trustedProxies: parseTrustedProxies(opt.TrustedProxies),
+
+		binding:           opt.Binding,
+		fingerprint:       opt.Fingerprint,
+		onBindingMismatch: opt.OnBindingMismatch,
+
+		regenerateOnAuth: opt.RegenerateOnAuth,
+		rotationInterval: opt.RotationInterval,
+		regenerateGrace:  opt.RegenerateGrace,
+
+		locker:      locker,
+		lockTimeout: opt.LockTimeout,
+		lockBypass:  opt.LockBypass,
+
+		versioned:          versioned,
+		concurrencyRetries: opt.ConcurrencyRetries,
 	}
 
 	return m.Handler
@@ -97,25 +220,112 @@ func HandlerWithOptions(opt Options) func(http.Handler) http.Handler {
 		WithStore(opt.Store),
 		WithCookieName(opt.CookieName),
 		WithSecret(opt.Secret),
+		WithKeyring(opt.Keyring),
+		WithEncryptedCookie(opt.EncryptCookie),
 		WithTTL(opt.TTL),
+		WithIdleTimeout(opt.IdleTimeout),
+		WithAbsoluteTimeout(opt.AbsoluteTimeout),
 		WithHTTPOnly(opt.HTTPOnly),
 		WithSecure(opt.Secure),
 		WithSameSite(opt.SameSite),
 		WithSaveUninitialized(opt.SaveUninitialized),
 		WithAutoRenew(opt.AutoRenew),
+		WithRenewThreshold(opt.RenewThreshold),
 		WithPath(opt.Path),
+		WithCookieChunkSize(opt.CookieChunkSize),
+		WithTransport(opt.Transport),
 		WithErrorHandler(opt.ErrorHandler),
+		WithMaxSessionsPerUser(opt.MaxSessionsPerUser),
+		WithSessionLimitPolicy(opt.SessionLimitPolicy),
+		WithTrackMetadata(opt.TrackMetadata),
+		WithTrustedProxies(opt.TrustedProxies...),
+		WithBinding(opt.Binding),
+		WithFingerprint(opt.Fingerprint),
+		WithBindingMismatchHook(opt.OnBindingMismatch),
+		WithRegenerateOnAuth(opt.RegenerateOnAuth),
+		WithRotationInterval(opt.RotationInterval),
+		WithRegenerateGrace(opt.RegenerateGrace),
+		WithLocking(opt.LockSessions),
+		WithLockTimeout(opt.LockTimeout),
+		WithLockBypass(opt.LockBypass),
+		WithOptimisticConcurrency(opt.OptimisticConcurrency),
+		WithConcurrencyRetries(opt.ConcurrencyRetries),
 	)
 }
 
+// lock takes the lock of the session named by the request, if any. Requests
+// without a valid session value are not locked since they cannot race on a
+// stored session. The lock is taken on the ID the value resolves to after
+// following redirects, so requests still carrying the pre-regeneration ID
+// serialize with those carrying the new one.
+func (m *Middleware) lock(r *http.Request) (func(), error) {
+	if m.locker == nil || (m.lockBypass != nil && m.lockBypass(r)) {
+		return func() {}, nil
+	}
+
+	value, err := m.transport.Read(r)
+	if err != nil {
+		return func() {}, nil
+	}
+
+	sessionID, err := m.decodeCookie(value)
+	if err != nil {
+		return func() {}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), m.lockTimeout)
+	defer cancel()
+
+	var release func() error
+	id := sessionID
+	for attempt := 0; ; attempt++ {
+		release, err = m.locker.Lock(ctx, id)
+		if errors.Is(err, context.DeadlineExceeded) {
+			m.log.Warnf("Timed out waiting for session lock: %s", id[:8]+"...")
+			return nil, ErrLockTimeout
+		}
+		if err != nil {
+			return nil, fmt.Errorf("lock session: %w", err)
+		}
+
+		// Resolve once the lock is held: a regeneration that finished while
+		// this request waited has moved the session to a new ID.
+		target := m.resolve(r.Context(), sessionID)
+		if target == id || attempt == maxRedirects {
+			break
+		}
+		if err := release(); err != nil {
+			m.log.Warnf("Failed to release session lock: %v", err)
+		}
+		id = target
+	}
+
+	return func() {
+		if err := release(); err != nil {
+			m.log.Warnf("Failed to release session lock: %v", err)
+		}
+	}, nil
+}
+
 func (m *Middleware) cleanupOldSession(session *Session) {
 	if !session.HasOldID() || session.IsNew() {
 		return
 	}
 
-	oldID := session.oldID
+	oldID := session.GetOldID()
+	redirect := newRedirect(oldID, session.GetSessionData().ID, now().Add(m.regenerateGrace))
+	// The ID a session had before a login or logout may be known to
+	// someone else, so it must not lead to the session afterwards.
+	grace := m.regenerateGrace > 0 && !session.isAuthChanged()
 	go func() {
 		ctx := context.Background()
+		if grace {
+			if err := m.store.Set(ctx, redirect); err != nil {
+				m.log.Warnf("Failed to redirect old session %s: %v", oldID[:8]+"...", err)
+			}
+			return
+		}
+
 		if err := m.store.Delete(ctx, oldID); err != nil {
 			m.log.Warnf("Failed to delete old session %s: %v", oldID[:8]+"...", err)
 		} else {
@@ -153,23 +363,164 @@ func (m *Middleware) commit(w http.ResponseWriter, r *http.Request) error {
 			m.log.Errorf("Failed to delete session: %v", err)
 			return fmt.Errorf("delete session: %w", err)
 		}
-		m.cleanCookie(w)
+		m.transport.Clear(w, r)
 		return nil
 	}
 
+	m.prepare(r, session)
+
+	err := m.persist(ctx, session)
+	if errors.Is(err, ErrSessionNotFound) {
+		// Deleted while the request ran, e.g. by a logout elsewhere or
+		// DeleteByUser. Writing or extending it must not bring it back.
+		m.transport.Clear(w, r)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := m.setCookie(w, r, session); err != nil {
+		m.log.Errorf("Failed to write session cookie: %v", err)
+		return fmt.Errorf("write cookie: %w", err)
+	}
+	return nil
+}
+
+// flush persists session before the response is written, for
+// Session.Flush. It runs the same steps as commit, which then has nothing
+// left to write unless the session changes again.
+func (m *Middleware) flush(ctx context.Context, r *http.Request, session *Session) error {
+	m.prepare(r, session)
+	return m.persist(ctx, session)
+}
+
+// prepare applies what the middleware changes on every session before it
+// is persisted: a new ID when due, client metadata, the fingerprint and
+// the lifetime of new sessions. Running it twice changes nothing.
+func (m *Middleware) prepare(r *http.Request, session *Session) {
+	if !session.IsNew() && !session.HasOldID() && m.shouldRegenerate(session) {
+		m.log.Debugf("Regenerating session: %s", session.ID[:8]+"...")
+		session.Regenerate()
+	}
+
+	if m.trackMetadata {
+		session.recordClient(clientIP(r, m.trustedProxies), r.UserAgent(), now())
+	}
+
+	if m.binding != BindingOff && (session.IsNew() || session.isAuthChanged() && session.IsAuthenticated()) {
+		session.bind(hashFingerprint(m.fingerprint(r)))
+	}
+
+	if session.IsNew() {
+		session.applyLifetime(m.idleTimeout, m.absoluteTimeout)
+	}
+}
+
+// persist enforces the session limit on a login and writes or extends the
+// session. It returns ErrSessionNotFound when the session was deleted while
+// the request ran.
+func (m *Middleware) persist(ctx context.Context, session *Session) error {
+	if m.maxSessionsPerUser > 0 && session.isAuthChanged() && session.IsAuthenticated() {
+		// Hold the user's lock until the session is saved, so concurrent
+		// logins cannot both see room for one more session.
+		unlock, err := m.lockUser(ctx, session.GetSessionData().UserID)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		if err := m.enforceSessionLimit(ctx, session); err != nil {
+			m.log.Warnf("Session limit enforcement failed: %v", err)
+			return fmt.Errorf("enforce session limit: %w", err)
+		}
+	}
+
 	if session.IsModified() {
-		m.cleanupOldSession(session)
-		if err := m.store.Set(ctx, session.SessionData); err != nil {
+		err := m.save(ctx, session)
+		if errors.Is(err, ErrSessionNotFound) {
+			m.log.Debugf("Session gone before save: %s", session.ID[:8]+"...")
+			return err
+		}
+		if err != nil {
 			m.log.Errorf("Failed to set session: %v", err)
 			return fmt.Errorf("save session: %w", err)
 		}
+		m.cleanupOldSession(session)
 		session.markPersisted()
+		session.MarkClean()
+	} else if session.IsTouched() {
+		err := m.touch(ctx, session)
+		if errors.Is(err, ErrSessionNotFound) {
+			m.log.Debugf("Session gone before touch: %s", session.ID[:8]+"...")
+			return err
+		}
+		if err != nil {
+			m.log.Errorf("Failed to touch session: %v", err)
+			return fmt.Errorf("touch session: %w", err)
+		}
+		session.MarkClean()
 	}
 
-	m.setCookie(w, session)
 	return nil
 }
 
+// lockUser serializes session limit checks for userID when the store
+// implements Locker. Without one the check is best-effort.
+func (m *Middleware) lockUser(ctx context.Context, userID string) (func(), error) {
+	l, ok := m.store.(Locker)
+	if !ok {
+		return func() {}, nil
+	}
+
+	lockCtx, cancel := context.WithTimeout(ctx, m.lockTimeout)
+	defer cancel()
+
+	release, err := l.Lock(lockCtx, "user:"+userID)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil, ErrLockTimeout
+	}
+	if err != nil {
+		return nil, fmt.Errorf("lock user sessions: %w", err)
+	}
+
+	return func() {
+		if err := release(); err != nil {
+			m.log.Warnf("Failed to release user lock: %v", err)
+		}
+	}, nil
+}
+
+// shouldRegenerate reports whether a loaded session needs a new ID because
+// its owner changed or its ID is due for rotation.
+func (m *Middleware) shouldRegenerate(session *Session) bool {
+	if m.regenerateOnAuth && session.isAuthChanged() {
+		return true
+	}
+	return m.rotationInterval > 0 && session.dueForRotation(m.rotationInterval)
+}
+
+// enforceSessionLimit applies the session limit policy when the session
+// was just authenticated and its user already holds too many others.
+func (m *Middleware) enforceSessionLimit(ctx context.Context, session *Session) error {
+	data := session.GetSessionData()
+	oldID := session.GetOldID()
+
+	active, err := m.store.(UserIndex).ListByUser(ctx, data.UserID)
+	if err != nil {
+		return err
+	}
+
+	active = slices.DeleteFunc(active, func(s SessionData) bool {
+		return s.ID == data.ID || s.ID == oldID
+	})
+	if len(active) < m.maxSessionsPerUser {
+		return nil
+	}
+
+	return m.sessionLimitPolicy(ctx, m.store, data, active, m.maxSessionsPerUser)
+}
+
 func (m *Middleware) onError(w http.ResponseWriter, r *http.Request, err error) {
 	if m.errorHandler != nil {
 		m.errorHandler(w, r, err)
@@ -180,102 +531,278 @@ func (m *Middleware) onError(w http.ResponseWriter, r *http.Request, err error)
 	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 }
 
-func (m *Middleware) setCookie(w http.ResponseWriter, session *Session) {
-	http.SetCookie(w, &http.Cookie{
-		Name:     m.cookieName,
-		Value:    session.SignedID(m.secret),
-		Path:     m.path,
-		Expires:  session.ExpiresAt,
-		MaxAge:   int(m.ttl.Seconds()),
-		Secure:   m.secure,
-		HttpOnly: m.httpOnly,
-		SameSite: m.sameSite,
-	})
+func (m *Middleware) setCookie(w http.ResponseWriter, r *http.Request, session *Session) error {
+	value, err := m.cookieValue(r.Context(), session)
+	if err != nil {
+		return err
+	}
+
+	return m.transport.Write(w, r, value, session.ExpiresAt)
 }
 
-func (m *Middleware) cleanCookie(w http.ResponseWriter) {
-	http.SetCookie(w, &http.Cookie{
-		Name:     m.cookieName,
-		Value:    "",
-		Path:     m.path,
-		Secure:   m.secure,
-		HttpOnly: m.httpOnly,
-		SameSite: m.sameSite,
-		MaxAge:   -1,
-		Expires:  time.Unix(0, 0),
-	})
+func (m *Middleware) cookieValue(ctx context.Context, session *Session) (string, error) {
+	if cs, ok := m.store.(CookieStore); ok {
+		return cs.Encode(ctx, session.GetSessionData())
+	}
+
+	return m.encodeCookie(session.ID), nil
 }
 
 func (m *Middleware) loadSession(r *http.Request) (*Session, error) {
 	ctx := r.Context()
-	cookie, err := r.Cookie(m.cookieName)
+	value, err := m.transport.Read(r)
 	if err != nil {
-		if errors.Is(err, http.ErrNoCookie) {
-			return nil, ErrNoCookie
+		if !isNoValue(err) {
+			m.log.Debugf("Session value read error: error=%v, path=%s",
+				err,
+				r.URL.Path,
+			)
 		}
-		m.log.Debugf("Cookie read error: cookieName=%s, error=%v, path=%s",
-			m.cookieName,
-			err,
-			r.URL.Path,
-		)
-		return nil, ErrInvalidCookie
-	}
-
-	if cookie.Value == "" {
-		return nil, ErrInvalidCookie
-	}
-
-	sessionID, err := m.unsignCookie(cookie.Value)
-	if err != nil {
 		return nil, err
 	}
 
-	data, err := m.store.Get(ctx, sessionID)
+	data, err := m.fetch(ctx, value)
 	if err != nil {
 		return nil, err
 	}
 
+	sessionID := data.ID
 	session := NewSessionFromData(data)
+	session.codec = m.codec
+	session.adoptAbsoluteTimeout(m.absoluteTimeout)
 
 	if session.IsExpired() {
-		m.log.Warnf("session [%s] expired", session.ID)
+		err := m.expiryReason(session)
+		m.log.Warnf("session [%s] expired: %v", session.ID, err)
 		go func() {
 			if err := m.store.Delete(context.Background(), sessionID); err != nil {
 				m.log.Warnf("session delete session failed: %v", err)
 			}
 		}()
-		return nil, ErrSessionExpired
+		return nil, err
 	}
 
-	if m.autoRenew {
-		session.Renew(m.ttl)
+	if err := m.checkBinding(r, session); err != nil {
+		return nil, err
+	}
+
+	if window := m.renewWindow(); window > 0 && m.shouldRenew(session, window) {
+		session.Touch(window)
 	}
 
 	return session, nil
 }
 
+// checkBinding compares the request against the fingerprint stored with
+// the session. An authenticated session without a fingerprint was never
+// bound to its owner and is treated as a mismatch.
+func (m *Middleware) checkBinding(r *http.Request, session *Session) error {
+	if m.binding == BindingOff {
+		return nil
+	}
+
+	data := session.GetSessionData()
+	if data.Fingerprint == "" && !data.Authenticated {
+		return nil
+	}
+	if data.Fingerprint != "" && fingerprintsEqual(data.Fingerprint, hashFingerprint(m.fingerprint(r))) {
+		return nil
+	}
+
+	if m.onBindingMismatch != nil {
+		m.onBindingMismatch(r, data)
+	}
+
+	m.log.Warnf("session [%s] fingerprint mismatch: path=%s", data.ID[:8]+"...", r.URL.Path)
+
+	switch m.binding {
+	case BindingStrict:
+		return ErrSessionBindingMismatch
+	case BindingLenient:
+		session.Unauthenticate()
+	}
+	return nil
+}
+
+// save persists a modified session. With optimistic concurrency a
+// conflicting write is resolved by re-applying this request's changes to
+// the latest stored copy.
+func (m *Middleware) save(ctx context.Context, session *Session) error {
+	if m.versioned == nil {
+		return m.set(ctx, session)
+	}
+
+	for attempt := 0; ; attempt++ {
+		data := session.GetSessionData()
+		err := m.versioned.CompareAndSet(ctx, data)
+		if err == nil {
+			session.setVersion(data.Version + 1)
+			return nil
+		}
+		if !errors.Is(err, ErrConcurrentModification) || attempt >= m.concurrencyRetries {
+			return err
+		}
+
+		m.log.Debugf("Session modified concurrently, retrying: %s", data.ID[:8]+"...")
+		latest, err := m.store.Get(ctx, data.ID)
+		if err != nil {
+			return fmt.Errorf("%w: reload: %w", ErrConcurrentModification, err)
+		}
+		session.rebase(latest)
+	}
+}
+
+// set writes a session, sending only the changed keys when the store
+// supports it and already holds the session under the same ID. It returns
+// ErrSessionNotFound when the stored copy was deleted in the meantime.
+func (m *Middleware) set(ctx context.Context, session *Session) error {
+	if p, ok := m.store.(PartialStore); ok && !session.IsNew() && !session.HasOldID() {
+		err := p.Update(ctx, session.GetSessionData(), session.Changes())
+		if !errors.Is(err, ErrPartialUpdateUnsupported) {
+			return err
+		}
+	}
+
+	return m.store.Set(ctx, session.GetSessionData())
+}
+
+// touch extends a session whose data did not change. A refreshed
+// LastSeenAt is written with a partial update where the store supports
+// one. It returns ErrSessionNotFound when the session no longer exists and
+// the store can tell.
+func (m *Middleware) touch(ctx context.Context, session *Session) error {
+	data := session.GetSessionData()
+	if session.isSeen() {
+		if p, ok := m.store.(PartialStore); ok {
+			err := p.Update(ctx, data, Changes{})
+			if !errors.Is(err, ErrPartialUpdateUnsupported) {
+				return err
+			}
+		}
+		return m.store.Set(ctx, data)
+	}
+
+	if t, ok := m.store.(Toucher); ok {
+		return t.Touch(ctx, data.ID, data.ExpiresAt)
+	}
+
+	return m.store.Set(ctx, data)
+}
+
+// renewWindow is how far each renewal moves the expiry forward, or zero
+// when sessions are not renewed.
+func (m *Middleware) renewWindow() time.Duration {
+	switch {
+	case m.idleTimeout > 0:
+		return m.idleTimeout
+	case m.autoRenew:
+		return m.ttl
+	default:
+		return 0
+	}
+}
+
+func (m *Middleware) shouldRenew(session *Session, window time.Duration) bool {
+	if m.renewThreshold <= 0 {
+		return true
+	}
+
+	remaining := session.GetSessionData().ExpiresAt.Sub(now())
+	return remaining < time.Duration(float64(window)*m.renewThreshold)
+}
+
+// expiryReason reports which limit an expired session ran into.
+func (m *Middleware) expiryReason(session *Session) error {
+	switch {
+	case session.IsAbsoluteExpired():
+		return ErrSessionAbsoluteTimeout
+	case m.idleTimeout > 0:
+		return ErrSessionIdleTimeout
+	default:
+		return ErrSessionExpired
+	}
+}
+
+func (m *Middleware) fetch(ctx context.Context, value string) (SessionData, error) {
+	if cs, ok := m.store.(CookieStore); ok {
+		return cs.Decode(ctx, value)
+	}
+
+	sessionID, err := m.decodeCookie(value)
+	if err != nil {
+		return SessionData{}, err
+	}
+
+	return m.get(ctx, sessionID)
+}
+
+// resolve returns the ID id leads to after following redirects, or id
+// itself when it does not lead to a session.
+func (m *Middleware) resolve(ctx context.Context, id string) string {
+	data, err := m.get(ctx, id)
+	if err != nil {
+		return id
+	}
+	return data.ID
+}
+
+// get loads a session, following the redirects left behind by Regenerate.
+func (m *Middleware) get(ctx context.Context, id string) (SessionData, error) {
+	for range maxRedirects + 1 {
+		data, err := m.store.Get(ctx, id)
+		if err != nil {
+			return SessionData{}, err
+		}
+
+		newID, ok := redirectTarget(data)
+		if !ok {
+			return data, nil
+		}
+		if data.IsExpired() {
+			return SessionData{}, ErrSessionNotFound
+		}
+
+		m.log.Debugf("Following session redirect: %s -> %s", id[:8]+"...", newID[:8]+"...")
+		id = newID
+	}
+
+	return SessionData{}, ErrSessionNotFound
+}
+
 func (m *Middleware) unsignCookie(signedValue string) (string, error) {
-	if !strings.HasPrefix(signedValue, "s:") || len(signedValue) < 2 {
-		return "", ErrInvalidSignature
+	return m.keyring.Verify(signedValue)
+}
+
+func (m *Middleware) encodeCookie(sessionID string) string {
+	if !m.encryptCookie {
+		return m.keyring.Sign(sessionID)
 	}
 
-	value := signedValue[2:]
+	plaintext := binary.BigEndian.AppendUint64(nil, uint64(now().Unix()))
+	plaintext = append(plaintext, sessionID...)
+	return m.keyring.Seal(plaintext)
+}
 
-	parts := strings.Split(value, ".")
-	if len(parts) != 2 {
-		return "", ErrInvalidSignature
+// decodeCookie accepts both sealed and signed values so that enabling
+// encryption does not log out sessions issued before the switch.
+func (m *Middleware) decodeCookie(value string) (string, error) {
+	if !strings.HasPrefix(value, "e:") {
+		return m.unsignCookie(value)
 	}
 
-	sessionID := parts[0]
-	receivedSig := parts[1]
+	plaintext, err := m.keyring.Open(value)
+	if err != nil {
+		return "", err
+	}
 
-	h := hmac.New(sha256.New, []byte(m.secret))
-	h.Write([]byte(sessionID))
-	expectedSig := base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+	if len(plaintext) <= 8 {
+		return "", ErrCookieTampered
+	}
 
-	if !hmac.Equal([]byte(receivedSig), []byte(expectedSig)) {
-		return "", ErrInvalidSignature
+	issuedAt := time.Unix(int64(binary.BigEndian.Uint64(plaintext[:8])), 0)
+	if now().After(issuedAt.Add(m.ttl)) {
+		return "", ErrCookieExpired
 	}
 
-	return sessionID, nil
+	return string(plaintext[8:]), nil
 }
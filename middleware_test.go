@@ -0,0 +1,1210 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type nopLogger struct{}
+
+func (nopLogger) Infof(string, ...interface{})  {}
+func (nopLogger) Debugf(string, ...interface{}) {}
+func (nopLogger) Errorf(string, ...interface{}) {}
+func (nopLogger) Warnf(string, ...interface{})  {}
+
+// countingStore records how the middleware persists sessions.
+type countingStore struct {
+	Store
+	sets    int
+	touches int
+}
+
+func (s *countingStore) Set(ctx context.Context, session SessionData) error {
+	s.sets++
+	return s.Store.Set(ctx, session)
+}
+
+type countingToucher struct {
+	*countingStore
+}
+
+func (s countingToucher) Touch(ctx context.Context, id string, expiresAt time.Time) error {
+	s.touches++
+	data, err := s.Store.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	data.ExpiresAt = expiresAt
+	return s.Store.Set(ctx, data)
+}
+
+func serve(t *testing.T, h http.Handler, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// newHandler wraps next in the middleware with store and a silent logger,
+// followed by opts.
+func newHandler(store Store, next http.HandlerFunc, opts ...func(*Options)) http.Handler {
+	opts = append([]func(*Options){WithLogger(nopLogger{}), WithStore(store)}, opts...)
+	return Handler(opts...)(next)
+}
+
+func noContent(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// withSession calls handle with the session of the request.
+func withSession(handle func(*Session)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handle(MustFromContext(r.Context()))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// capture stores the session of the request, or nil, in loaded.
+func capture(loaded **Session) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		*loaded, _ = FromContext(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func responseCookie(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func storedSession(t *testing.T, store Store, ttl time.Duration) SessionData {
+	t.Helper()
+
+	data := NewSessionData(ttl)
+	require.NoError(t, store.Set(context.Background(), data))
+	return data
+}
+
+func TestMiddleware_Keyring(t *testing.T) {
+	t.Run("should re-sign cookies signed with a previous key", func(t *testing.T) {
+		store := NewMemoryStore()
+		data := storedSession(t, store, time.Hour)
+
+		oldRing := NewKeyring(Key{ID: "k1", Secret: []byte("old")})
+		ring := NewKeyring(Key{ID: "k2", Secret: []byte("new")}, Key{ID: "k1", Secret: []byte("old")})
+
+		var loaded *Session
+		h := newHandler(store, capture(&loaded), WithKeyring(ring))
+
+		w := serve(t, h, &http.Cookie{Name: "sid", Value: oldRing.Sign(data.ID)})
+
+		require.NotNil(t, loaded)
+		assert.Equal(t, data.ID, loaded.ID)
+
+		c := responseCookie(w, "sid")
+		require.NotNil(t, c)
+		assert.Equal(t, ring.Sign(data.ID), c.Value)
+	})
+
+	t.Run("should fall back to the secret when no keyring is set", func(t *testing.T) {
+		store := NewMemoryStore()
+		data := storedSession(t, store, time.Hour)
+
+		var loaded *Session
+		h := newHandler(store, capture(&loaded), WithSecret("plain"))
+
+		serve(t, h, &http.Cookie{Name: "sid", Value: encodeSessionId(data.ID, "plain")})
+
+		require.NotNil(t, loaded)
+		assert.Equal(t, data.ID, loaded.ID)
+	})
+}
+
+func TestMiddleware_EncryptedCookie(t *testing.T) {
+	encrypted := []func(*Options){
+		WithKeyring(NewKeyring(Key{ID: "k1", Secret: []byte("secret")})),
+		WithEncryptedCookie(true),
+		WithSaveUninitialized(true),
+	}
+
+	t.Run("should not expose the session id in the cookie", func(t *testing.T) {
+		var loaded *Session
+		h := newHandler(NewMemoryStore(), capture(&loaded), encrypted...)
+
+		w := serve(t, h)
+
+		c := responseCookie(w, "sid")
+		require.NotNil(t, c)
+		require.NotNil(t, loaded)
+		assert.True(t, strings.HasPrefix(c.Value, "e:k1:"))
+		assert.NotContains(t, c.Value, loaded.ID)
+
+		firstID := loaded.ID
+		serve(t, h, c)
+		assert.Equal(t, firstID, loaded.ID)
+	})
+
+	t.Run("should reject expired envelopes", func(t *testing.T) {
+		var loaded *Session
+		store := NewMemoryStore()
+		h := newHandler(store, capture(&loaded), encrypted...)
+		w := serve(t, h)
+		c := responseCookie(w, "sid")
+		firstID := loaded.ID
+
+		now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+		defer func() { now = time.Now }()
+
+		m := &Middleware{ttl: time.Hour, keyring: NewKeyring(Key{ID: "k1", Secret: []byte("secret")})}
+		_, err := m.decodeCookie(c.Value)
+		assert.ErrorIs(t, err, ErrCookieExpired)
+
+		serve(t, h, c)
+		assert.NotEqual(t, firstID, loaded.ID)
+	})
+
+	t.Run("should still accept signed cookies", func(t *testing.T) {
+		var loaded *Session
+		store := NewMemoryStore()
+		data := storedSession(t, store, time.Hour)
+		h := newHandler(store, capture(&loaded), encrypted...)
+
+		signed := NewKeyring(Key{ID: "k1", Secret: []byte("secret")}).Sign(data.ID)
+		w := serve(t, h, &http.Cookie{Name: "sid", Value: signed})
+
+		assert.Equal(t, data.ID, loaded.ID)
+		assert.True(t, strings.HasPrefix(responseCookie(w, "sid").Value, "e:k1:"))
+	})
+}
+
+func TestMiddleware_Lifetime(t *testing.T) {
+	t.Run("should set the idle window and absolute deadline on new sessions", func(t *testing.T) {
+		var loaded *Session
+		h := newHandler(NewMemoryStore(), capture(&loaded),
+			WithSaveUninitialized(true),
+			WithIdleTimeout(15*time.Minute),
+			WithAbsoluteTimeout(8*time.Hour),
+		)
+
+		serve(t, h)
+
+		require.NotNil(t, loaded)
+		assert.Equal(t, loaded.CreatedAt.Add(8*time.Hour), loaded.AbsoluteExpiresAt)
+		assert.True(t, loaded.ExpiresAt.Before(loaded.CreatedAt.Add(16*time.Minute)))
+	})
+
+	t.Run("should slide the idle window but never past the absolute deadline", func(t *testing.T) {
+		store := NewMemoryStore()
+		var loaded *Session
+		h := newHandler(store, capture(&loaded),
+			WithSaveUninitialized(true),
+			WithIdleTimeout(15*time.Minute),
+			WithAbsoluteTimeout(time.Hour),
+		)
+
+		w := serve(t, h)
+		c := responseCookie(w, "sid")
+		id := loaded.ID
+
+		defer func() { now = time.Now }()
+		for _, offset := range []time.Duration{10, 20, 30, 40, 50} {
+			at := time.Now().Add(offset * time.Minute)
+			now = func() time.Time { return at }
+			serve(t, h, c)
+			require.Equal(t, id, loaded.ID, "offset %d", offset)
+		}
+
+		assert.Equal(t, loaded.AbsoluteExpiresAt, loaded.ExpiresAt)
+	})
+
+	t.Run("should apply the absolute deadline to existing sessions", func(t *testing.T) {
+		store := NewMemoryStore()
+		var loaded *Session
+		h := newHandler(store, capture(&loaded), WithSaveUninitialized(true), WithAbsoluteTimeout(8*time.Hour))
+
+		legacy := NewSessionData(24 * time.Hour)
+		legacy.CreatedAt = time.Now().Add(-time.Hour)
+		require.NoError(t, store.Set(context.Background(), legacy))
+
+		serve(t, h, &http.Cookie{Name: "sid", Value: NewKeyring(Key{Secret: []byte("secret")}).Sign(legacy.ID)})
+
+		require.NotNil(t, loaded)
+		assert.Equal(t, legacy.ID, loaded.ID)
+		want := legacy.CreatedAt.Add(8 * time.Hour)
+		assert.Equal(t, want, loaded.AbsoluteExpiresAt)
+		assert.Equal(t, want, loaded.ExpiresAt)
+
+		stored, err := store.Get(context.Background(), legacy.ID)
+		require.NoError(t, err)
+		assert.Equal(t, want, stored.AbsoluteExpiresAt)
+	})
+
+	t.Run("should expire existing sessions past the absolute deadline", func(t *testing.T) {
+		store := NewMemoryStore()
+		var loaded *Session
+		h := newHandler(store, capture(&loaded), WithSaveUninitialized(true), WithAbsoluteTimeout(8*time.Hour))
+
+		legacy := NewSessionData(24 * time.Hour)
+		legacy.CreatedAt = time.Now().Add(-9 * time.Hour)
+		require.NoError(t, store.Set(context.Background(), legacy))
+
+		serve(t, h, &http.Cookie{Name: "sid", Value: NewKeyring(Key{Secret: []byte("secret")}).Sign(legacy.ID)})
+
+		require.NotNil(t, loaded)
+		assert.NotEqual(t, legacy.ID, loaded.ID)
+	})
+
+	t.Run("should report idle expiry", func(t *testing.T) {
+		m := &Middleware{idleTimeout: 15 * time.Minute, absoluteTimeout: time.Hour}
+
+		data := NewSessionData(15 * time.Minute)
+		data.SetAbsoluteTimeout(time.Hour)
+
+		now = func() time.Time { return time.Now().Add(20 * time.Minute) }
+		defer func() { now = time.Now }()
+
+		err := m.expiryReason(NewSessionFromData(data))
+		assert.ErrorIs(t, err, ErrSessionIdleTimeout)
+		assert.ErrorIs(t, err, ErrSessionExpired)
+	})
+
+	t.Run("should report absolute expiry", func(t *testing.T) {
+		m := &Middleware{idleTimeout: 15 * time.Minute, absoluteTimeout: time.Hour}
+
+		data := NewSessionData(15 * time.Minute)
+		data.SetAbsoluteTimeout(time.Hour)
+
+		now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+		defer func() { now = time.Now }()
+
+		err := m.expiryReason(NewSessionFromData(data))
+		assert.ErrorIs(t, err, ErrSessionAbsoluteTimeout)
+		assert.ErrorIs(t, err, ErrSessionExpired)
+	})
+}
+
+func TestMiddleware_Renew(t *testing.T) {
+	t.Run("should renew on every request without a threshold", func(t *testing.T) {
+		store := &countingStore{Store: NewMemoryStore()}
+		data := storedSession(t, store, time.Hour)
+		store.sets = 0
+		h := newHandler(store, noContent, WithAutoRenew(true))
+		c := &http.Cookie{Name: "sid", Value: encodeSessionId(data.ID, "secret")}
+
+		serve(t, h, c)
+		serve(t, h, c)
+
+		assert.Equal(t, 2, store.sets)
+	})
+
+	t.Run("should skip renewals while enough of the window remains", func(t *testing.T) {
+		store := &countingStore{Store: NewMemoryStore()}
+		data := storedSession(t, store, time.Hour)
+		store.sets = 0
+		h := newHandler(store, noContent, WithAutoRenew(true), WithRenewThreshold(0.5))
+		c := &http.Cookie{Name: "sid", Value: encodeSessionId(data.ID, "secret")}
+
+		serve(t, h, c)
+		assert.Equal(t, 0, store.sets)
+
+		defer func() { now = time.Now }()
+		at := time.Now().Add(40 * time.Minute)
+		now = func() time.Time { return at }
+
+		serve(t, h, c)
+		assert.Equal(t, 1, store.sets)
+
+		stored, err := store.Get(context.Background(), data.ID)
+		require.NoError(t, err)
+		assert.Equal(t, at.Add(time.Hour), stored.ExpiresAt)
+	})
+
+	t.Run("should touch instead of set when the store supports it", func(t *testing.T) {
+		counting := &countingStore{Store: NewMemoryStore()}
+		store := countingToucher{counting}
+		data := storedSession(t, store, time.Hour)
+		counting.sets = 0
+		h := newHandler(store, noContent, WithAutoRenew(true))
+
+		serve(t, h, &http.Cookie{Name: "sid", Value: encodeSessionId(data.ID, "secret")})
+
+		assert.Equal(t, 1, counting.touches)
+		assert.Equal(t, 0, counting.sets)
+	})
+
+	t.Run("should not bring back sessions deleted during the request", func(t *testing.T) {
+		counting := &countingStore{Store: NewMemoryStore()}
+		store := countingToucher{counting}
+		data := storedSession(t, store, time.Hour)
+		counting.sets = 0
+		h := newHandler(store, func(w http.ResponseWriter, r *http.Request) {
+			require.NoError(t, store.Delete(r.Context(), data.ID))
+			w.WriteHeader(http.StatusNoContent)
+		}, WithAutoRenew(true))
+
+		w := serve(t, h, &http.Cookie{Name: "sid", Value: encodeSessionId(data.ID, "secret")})
+
+		assert.Equal(t, 0, counting.sets)
+		_, err := store.Get(context.Background(), data.ID)
+		assert.ErrorIs(t, err, ErrSessionNotFound)
+
+		c := responseCookie(w, "sid")
+		require.NotNil(t, c)
+		assert.Equal(t, -1, c.MaxAge)
+	})
+}
+
+func TestMiddleware_SessionLimit(t *testing.T) {
+	authenticate := func(w http.ResponseWriter, r *http.Request) {
+		GetOrCreate(r.Context(), time.Hour).Authenticate("user-1")
+		w.WriteHeader(http.StatusNoContent)
+	}
+
+	login := func(t *testing.T, store Store, userID string, createdAt time.Time) SessionData {
+		t.Helper()
+
+		data := NewSessionData(time.Hour)
+		data.CreatedAt = createdAt
+		data.Authenticate(userID)
+		require.NoError(t, store.Set(context.Background(), data))
+		return data
+	}
+
+	t.Run("should evict the oldest session by default", func(t *testing.T) {
+		store := NewMemoryStore()
+		oldest := login(t, store, "user-1", time.Now().Add(-time.Hour))
+		newest := login(t, store, "user-1", time.Now())
+
+		w := serve(t, newHandler(store, authenticate, WithMaxSessionsPerUser(2)))
+		assert.Equal(t, http.StatusNoContent, w.Code)
+
+		_, err := store.Get(context.Background(), oldest.ID)
+		assert.ErrorIs(t, err, ErrSessionNotFound)
+		_, err = store.Get(context.Background(), newest.ID)
+		assert.NoError(t, err)
+
+		count, err := store.CountByUser(context.Background(), "user-1")
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("should reject the login with a typed error", func(t *testing.T) {
+		store := NewMemoryStore()
+		login(t, store, "user-1", time.Now().Add(-time.Hour))
+		login(t, store, "user-1", time.Now())
+
+		var got error
+		h := newHandler(store, authenticate,
+			WithMaxSessionsPerUser(2),
+			WithSessionLimitPolicy(RejectNewLogin),
+			WithErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+				got = err
+				w.WriteHeader(http.StatusConflict)
+			}),
+		)
+
+		w := serve(t, h)
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.ErrorIs(t, got, ErrSessionLimitExceeded)
+
+		var limitErr *SessionLimitError
+		require.ErrorAs(t, got, &limitErr)
+		assert.Equal(t, "user-1", limitErr.UserID)
+		assert.Equal(t, 2, limitErr.Limit)
+		assert.Nil(t, responseCookie(w, "sid"))
+
+		count, err := store.CountByUser(context.Background(), "user-1")
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("should call a custom policy", func(t *testing.T) {
+		store := NewMemoryStore()
+		login(t, store, "user-1", time.Now().Add(-time.Hour))
+		login(t, store, "user-1", time.Now())
+
+		var active []SessionData
+		h := newHandler(store, authenticate, WithMaxSessionsPerUser(2), WithSessionLimitPolicy(
+			func(ctx context.Context, store Store, current SessionData, sessions []SessionData, limit int) error {
+				active = sessions
+				return nil
+			},
+		))
+
+		serve(t, h)
+		assert.Len(t, active, 2)
+	})
+
+	t.Run("should enforce the limit on Flush", func(t *testing.T) {
+		store := NewMemoryStore()
+		login(t, store, "user-1", time.Now().Add(-time.Hour))
+		login(t, store, "user-1", time.Now())
+
+		var flushed error
+		h := newHandler(store, func(w http.ResponseWriter, r *http.Request) {
+			sess := GetOrCreate(r.Context(), time.Hour).Authenticate("user-1")
+			flushed = sess.Flush(r.Context())
+			sess.Destroy()
+			w.WriteHeader(http.StatusNoContent)
+		}, WithMaxSessionsPerUser(2), WithSessionLimitPolicy(RejectNewLogin))
+
+		serve(t, h)
+		assert.ErrorIs(t, flushed, ErrSessionLimitExceeded)
+
+		count, err := store.CountByUser(context.Background(), "user-1")
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("should not exceed the limit with concurrent logins", func(t *testing.T) {
+		store := slowIndexStore{NewMemoryStore()}
+		h := newHandler(store, authenticate, WithMaxSessionsPerUser(2))
+
+		var wg sync.WaitGroup
+		for range 5 {
+			wg.Go(func() { serve(t, h) })
+		}
+		wg.Wait()
+
+		count, err := store.CountByUser(context.Background(), "user-1")
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("should take the user lock in the scope of the session lock", func(t *testing.T) {
+		store := &scopeRecordingStore{MemoryStore: NewMemoryStore()}
+		data := storedSession(t, store, time.Hour)
+
+		serve(t, newHandler(store, authenticate, WithMaxSessionsPerUser(2), WithLocking(true)), &http.Cookie{Name: "sid", Value: encodeSessionId(data.ID, "secret")})
+
+		require.Len(t, store.scopes, 2)
+		assert.NotNil(t, store.scopes[0])
+		assert.Same(t, store.scopes[0], store.scopes[1])
+	})
+
+	t.Run("should allow logins below the limit", func(t *testing.T) {
+		store := NewMemoryStore()
+		login(t, store, "user-1", time.Now())
+
+		w := serve(t, newHandler(store, authenticate, WithMaxSessionsPerUser(2), WithSessionLimitPolicy(RejectNewLogin)))
+		assert.Equal(t, http.StatusNoContent, w.Code)
+	})
+
+	t.Run("should panic when the store has no user index", func(t *testing.T) {
+		assert.Panics(t, func() {
+			Handler(WithStore(NewCookieStore(NewKeyring(Key{Secret: []byte("secret")}), 0)), WithMaxSessionsPerUser(1))
+		})
+	})
+}
+
+func TestMiddleware_Metadata(t *testing.T) {
+	const userAgent = "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0"
+
+	request := func(h http.Handler, id string) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "10.0.0.1:4000"
+		r.Header.Set("X-Forwarded-For", "198.51.100.1")
+		r.Header.Set("User-Agent", userAgent)
+		r.AddCookie(&http.Cookie{Name: "sid", Value: encodeSessionId(id, "secret")})
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	t.Run("should record client metadata", func(t *testing.T) {
+		store := NewMemoryStore()
+		data := storedSession(t, store, time.Hour)
+
+		request(newHandler(store, noContent, WithTrackMetadata(true), WithTrustedProxies("10.0.0.0/8")), data.ID)
+
+		stored, err := store.Get(context.Background(), data.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "198.51.100.1", stored.IP)
+		assert.Equal(t, userAgent, stored.UserAgent)
+		assert.Equal(t, "Firefox on Linux", stored.Device)
+		assert.False(t, stored.LastSeenAt.IsZero())
+	})
+
+	t.Run("should only refresh last seen once a minute", func(t *testing.T) {
+		store := &countingStore{Store: NewMemoryStore()}
+		data := storedSession(t, store, time.Hour)
+		h := newHandler(store, noContent, WithTrackMetadata(true), WithTrustedProxies("10.0.0.0/8"))
+
+		request(h, data.ID)
+		store.sets = 0
+
+		request(h, data.ID)
+		assert.Equal(t, 0, store.sets)
+
+		defer func() { now = time.Now }()
+		at := time.Now().Add(2 * time.Minute)
+		now = func() time.Time { return at }
+
+		request(h, data.ID)
+		assert.Equal(t, 1, store.sets)
+	})
+
+	t.Run("should refresh last seen with a partial update", func(t *testing.T) {
+		memory := NewMemoryStore()
+		store := &countingPartialStore{countingStore: &countingStore{Store: memory}}
+		data := storedSession(t, store, time.Hour)
+		h := newHandler(versionedPartialStore{store, memory}, noContent, WithTrackMetadata(true), WithOptimisticConcurrency(true))
+
+		request(h, data.ID)
+		store.sets, store.updates = 0, 0
+
+		defer func() { now = time.Now }()
+		at := time.Now().Add(2 * time.Minute)
+		now = func() time.Time { return at }
+
+		request(h, data.ID)
+		assert.Equal(t, 0, store.sets)
+		assert.Equal(t, 1, store.updates)
+
+		stored, err := store.Get(context.Background(), data.ID)
+		require.NoError(t, err)
+		assert.True(t, at.Equal(stored.LastSeenAt))
+		assert.Equal(t, int64(1), stored.Version)
+	})
+}
+
+func TestMiddleware_Binding(t *testing.T) {
+	// guarded logs in on /login and otherwise requires an authenticated
+	// session.
+	guarded := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			GetOrCreate(r.Context(), time.Hour).Authenticate("user-1")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if !HasSession(r.Context()) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !MustFromContext(r.Context()).IsAuthenticated() {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+
+	request := func(h http.Handler, id, userAgent string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("User-Agent", userAgent)
+		r.AddCookie(&http.Cookie{Name: "sid", Value: encodeSessionId(id, "secret")})
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// login authenticates a new session from userAgent and returns its ID.
+	login := func(t *testing.T, h http.Handler, userAgent string) string {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodGet, "/login", nil)
+		r.Header.Set("User-Agent", userAgent)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		c := responseCookie(w, "sid")
+		require.NotNil(t, c)
+		id, err := NewKeyring(Key{Secret: []byte("secret")}).Verify(c.Value)
+		require.NoError(t, err)
+		return id
+	}
+
+	t.Run("should bind sessions to the request that authenticated them", func(t *testing.T) {
+		store := NewMemoryStore()
+		id := login(t, newHandler(store, guarded, WithBinding(BindingStrict)), "agent")
+
+		stored, err := store.Get(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, hashFingerprint("agent"), stored.Fingerprint)
+	})
+
+	t.Run("should not bind existing sessions on later requests", func(t *testing.T) {
+		store := NewMemoryStore()
+		data := storedSession(t, store, time.Hour)
+
+		request(newHandler(store, guarded, WithBinding(BindingStrict)), data.ID, "agent")
+
+		stored, err := store.Get(context.Background(), data.ID)
+		require.NoError(t, err)
+		assert.Empty(t, stored.Fingerprint)
+	})
+
+	t.Run("should bind existing sessions on login", func(t *testing.T) {
+		store := NewMemoryStore()
+		data := storedSession(t, store, time.Hour)
+
+		h := newHandler(store, withSession(func(s *Session) { s.Authenticate("user-1") }), WithBinding(BindingStrict))
+		request(h, data.ID, "agent")
+
+		stored, err := store.Get(context.Background(), data.ID)
+		require.NoError(t, err)
+		assert.True(t, stored.Authenticated)
+		assert.Equal(t, hashFingerprint("agent"), stored.Fingerprint)
+	})
+
+	t.Run("should reject mismatches in strict mode", func(t *testing.T) {
+		store := NewMemoryStore()
+
+		var mismatched []SessionData
+		h := newHandler(store, guarded,
+			WithBinding(BindingStrict),
+			WithBindingMismatchHook(func(r *http.Request, session SessionData) {
+				mismatched = append(mismatched, session)
+			}),
+		)
+		id := login(t, h, "agent")
+
+		assert.Equal(t, http.StatusNoContent, request(h, id, "agent").Code)
+		assert.Equal(t, http.StatusUnauthorized, request(h, id, "stolen").Code)
+		assert.Equal(t, http.StatusNoContent, request(h, id, "agent").Code)
+
+		require.Len(t, mismatched, 1)
+		assert.Equal(t, id, mismatched[0].ID)
+	})
+
+	t.Run("should unauthenticate mismatches in lenient mode", func(t *testing.T) {
+		store := NewMemoryStore()
+		h := newHandler(store, guarded, WithBinding(BindingLenient))
+		id := login(t, h, "agent")
+
+		assert.Equal(t, http.StatusForbidden, request(h, id, "stolen").Code)
+
+		stored, err := store.Get(context.Background(), id)
+		require.NoError(t, err)
+		assert.False(t, stored.Authenticated)
+	})
+
+	t.Run("should only report mismatches in log-only mode", func(t *testing.T) {
+		store := NewMemoryStore()
+
+		calls := 0
+		h := newHandler(store, guarded,
+			WithBinding(BindingLogOnly),
+			WithBindingMismatchHook(func(*http.Request, SessionData) { calls++ }),
+		)
+		id := login(t, h, "agent")
+
+		request(h, id, "agent")
+		assert.Equal(t, http.StatusNoContent, request(h, id, "stolen").Code)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("should treat authenticated sessions without a fingerprint as mismatches", func(t *testing.T) {
+		store := NewMemoryStore()
+		data := NewSessionData(time.Hour)
+		data.Authenticate("user-1")
+		require.NoError(t, store.Set(context.Background(), data))
+
+		assert.Equal(t, http.StatusUnauthorized, request(newHandler(store, guarded, WithBinding(BindingStrict)), data.ID, "agent").Code)
+
+		assert.Equal(t, http.StatusForbidden, request(newHandler(store, guarded, WithBinding(BindingLenient)), data.ID, "agent").Code)
+		stored, err := store.Get(context.Background(), data.ID)
+		require.NoError(t, err)
+		assert.False(t, stored.Authenticated)
+		assert.Empty(t, stored.Fingerprint)
+	})
+}
+
+func TestMiddleware_Regenerate(t *testing.T) {
+	login := withSession(func(s *Session) { s.Authenticate("user-1") })
+
+	cookieID := func(t *testing.T, w *httptest.ResponseRecorder) string {
+		t.Helper()
+
+		c := responseCookie(w, "sid")
+		require.NotNil(t, c)
+		id, err := NewKeyring(Key{Secret: []byte("secret")}).Verify(c.Value)
+		require.NoError(t, err)
+		return id
+	}
+
+	t.Run("should regenerate the id on login", func(t *testing.T) {
+		store := NewMemoryStore()
+		data := storedSession(t, store, time.Hour)
+		h := newHandler(store, login, WithRegenerateOnAuth(true))
+
+		w := serve(t, h, &http.Cookie{Name: "sid", Value: encodeSessionId(data.ID, "secret")})
+
+		newID := cookieID(t, w)
+		assert.NotEqual(t, data.ID, newID)
+
+		stored, err := store.Get(context.Background(), newID)
+		require.NoError(t, err)
+		assert.Equal(t, "user-1", stored.UserID)
+		assert.False(t, stored.RotatedAt.IsZero())
+	})
+
+	t.Run("should keep the old id valid for the grace period", func(t *testing.T) {
+		store := NewMemoryStore()
+		data := storedSession(t, store, time.Hour)
+		h := newHandler(store, withSession(func(s *Session) { s.Regenerate() }), WithRegenerateGrace(time.Minute))
+		c := &http.Cookie{Name: "sid", Value: encodeSessionId(data.ID, "secret")}
+
+		newID := cookieID(t, serve(t, h, c))
+		h = newHandler(store, noContent, WithRegenerateGrace(time.Minute))
+
+		assert.Eventually(t, func() bool {
+			old, err := store.Get(context.Background(), data.ID)
+			target, ok := redirectTarget(old)
+			return err == nil && ok && target == newID
+		}, time.Second, 10*time.Millisecond)
+
+		assert.Equal(t, newID, cookieID(t, serve(t, h, c)))
+	})
+
+	t.Run("should not let the pre-login id reach the authenticated session", func(t *testing.T) {
+		store := NewMemoryStore()
+		data := storedSession(t, store, time.Hour)
+		h := newHandler(store, login, WithRegenerateOnAuth(true), WithRegenerateGrace(time.Minute))
+		c := &http.Cookie{Name: "sid", Value: encodeSessionId(data.ID, "secret")}
+
+		newID := cookieID(t, serve(t, h, c))
+		assert.NotEqual(t, data.ID, newID)
+
+		assert.Eventually(t, func() bool {
+			_, err := store.Get(context.Background(), data.ID)
+			return errors.Is(err, ErrSessionNotFound)
+		}, time.Second, 10*time.Millisecond)
+
+		m := &Middleware{log: nopLogger{}, store: store}
+		_, err := m.get(context.Background(), data.ID)
+		assert.ErrorIs(t, err, ErrSessionNotFound)
+		assert.Nil(t, responseCookie(serve(t, newHandler(store, noContent), c), "sid"))
+	})
+
+	t.Run("should follow redirects left by repeated regenerations", func(t *testing.T) {
+		store := NewMemoryStore()
+		data := storedSession(t, store, time.Hour)
+		first, second := generateId(), generateId()
+		expiresAt := time.Now().Add(time.Minute)
+		require.NoError(t, store.Set(context.Background(), newRedirect(first, second, expiresAt)))
+		require.NoError(t, store.Set(context.Background(), newRedirect(second, data.ID, expiresAt)))
+		h := newHandler(store, noContent)
+
+		w := serve(t, h, &http.Cookie{Name: "sid", Value: encodeSessionId(first, "secret")})
+
+		assert.Equal(t, data.ID, cookieID(t, w))
+	})
+
+	t.Run("should not follow expired redirects", func(t *testing.T) {
+		store := NewMemoryStore()
+		data := storedSession(t, store, time.Hour)
+		require.NoError(t, store.Set(context.Background(), newRedirect("old", data.ID, time.Now().Add(-time.Second))))
+
+		m := &Middleware{log: nopLogger{}, store: store}
+		_, err := m.get(context.Background(), "old")
+
+		assert.ErrorIs(t, err, ErrSessionNotFound)
+	})
+
+	t.Run("should delete the old id without a grace period", func(t *testing.T) {
+		store := NewMemoryStore()
+		data := storedSession(t, store, time.Hour)
+		h := newHandler(store, login, WithRegenerateOnAuth(true), WithRegenerateGrace(0))
+
+		serve(t, h, &http.Cookie{Name: "sid", Value: encodeSessionId(data.ID, "secret")})
+
+		assert.Eventually(t, func() bool {
+			_, err := store.Get(context.Background(), data.ID)
+			return errors.Is(err, ErrSessionNotFound)
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("should not regenerate when the owner is unchanged", func(t *testing.T) {
+		store := NewMemoryStore()
+		data := NewSessionData(time.Hour)
+		data.Authenticate("user-1")
+		require.NoError(t, store.Set(context.Background(), data))
+		h := newHandler(store, login, WithRegenerateOnAuth(true))
+
+		w := serve(t, h, &http.Cookie{Name: "sid", Value: encodeSessionId(data.ID, "secret")})
+
+		assert.Equal(t, data.ID, cookieID(t, w))
+	})
+
+	t.Run("should rotate ids older than the interval", func(t *testing.T) {
+		store := NewMemoryStore()
+		data := storedSession(t, store, time.Hour)
+		h := newHandler(store, noContent, WithRotationInterval(10*time.Minute))
+		c := &http.Cookie{Name: "sid", Value: encodeSessionId(data.ID, "secret")}
+
+		assert.Equal(t, data.ID, cookieID(t, serve(t, h, c)))
+
+		defer func() { now = time.Now }()
+		at := time.Now().Add(11 * time.Minute)
+		now = func() time.Time { return at }
+
+		assert.NotEqual(t, data.ID, cookieID(t, serve(t, h, c)))
+	})
+}
+
+func TestMiddleware_Locking(t *testing.T) {
+	t.Run("should serialize requests for the same session", func(t *testing.T) {
+		store := NewMemoryStore()
+		data := storedSession(t, store, time.Hour)
+		c := &http.Cookie{Name: "sid", Value: encodeSessionId(data.ID, "secret")}
+
+		h := newHandler(store, func(w http.ResponseWriter, r *http.Request) {
+			sess := MustFromContext(r.Context())
+			count, _ := GetAs[int](sess, "count")
+			time.Sleep(10 * time.Millisecond)
+			SetAs(sess, "count", count+1)
+			w.WriteHeader(http.StatusNoContent)
+		}, WithLocking(true))
+
+		var wg sync.WaitGroup
+		for range 5 {
+			wg.Go(func() { serve(t, h, c) })
+		}
+		wg.Wait()
+
+		stored, err := store.Get(context.Background(), data.ID)
+		require.NoError(t, err)
+		assert.Equal(t, 5, stored.Data["count"])
+	})
+
+	t.Run("should fail with ErrLockTimeout", func(t *testing.T) {
+		store := NewMemoryStore()
+		data := storedSession(t, store, time.Hour)
+		unlock, err := store.Lock(context.Background(), data.ID)
+		require.NoError(t, err)
+		defer unlock()
+
+		var got error
+		h := newHandler(store, noContent,
+			WithLocking(true),
+			WithLockTimeout(10*time.Millisecond),
+			WithErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+				got = err
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}),
+		)
+
+		w := serve(t, h, &http.Cookie{Name: "sid", Value: encodeSessionId(data.ID, "secret")})
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.ErrorIs(t, got, ErrLockTimeout)
+	})
+
+	t.Run("should wait the default timeout when HandlerWithOptions leaves it unset", func(t *testing.T) {
+		store := NewMemoryStore()
+		data := storedSession(t, store, time.Hour)
+		unlock, err := store.Lock(context.Background(), data.ID)
+		require.NoError(t, err)
+		time.AfterFunc(20*time.Millisecond, func() { _ = unlock() })
+
+		h := HandlerWithOptions(Options{
+			Logger:       nopLogger{},
+			Store:        store,
+			CookieName:   "sid",
+			Secret:       "secret",
+			TTL:          time.Hour,
+			LockSessions: true,
+		})(http.HandlerFunc(noContent))
+
+		w := serve(t, h, &http.Cookie{Name: "sid", Value: encodeSessionId(data.ID, "secret")})
+		assert.Equal(t, http.StatusNoContent, w.Code)
+	})
+
+	t.Run("should lock the session an old id redirects to", func(t *testing.T) {
+		store := NewMemoryStore()
+		data := storedSession(t, store, time.Hour)
+		oldID := generateId()
+		require.NoError(t, store.Set(context.Background(), newRedirect(oldID, data.ID, time.Now().Add(time.Minute))))
+		unlock, err := store.Lock(context.Background(), data.ID)
+		require.NoError(t, err)
+		defer unlock()
+
+		var got error
+		h := newHandler(store, noContent,
+			WithLocking(true),
+			WithLockTimeout(10*time.Millisecond),
+			WithErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+				got = err
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}),
+		)
+
+		serve(t, h, &http.Cookie{Name: "sid", Value: encodeSessionId(oldID, "secret")})
+		assert.ErrorIs(t, got, ErrLockTimeout)
+	})
+
+	t.Run("should follow a regeneration that finished while waiting", func(t *testing.T) {
+		store := NewMemoryStore()
+		data := storedSession(t, store, time.Hour)
+		newID := generateId()
+		unlock, err := store.Lock(context.Background(), data.ID)
+		require.NoError(t, err)
+
+		var locked error
+		h := newHandler(store, func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), 10*time.Millisecond)
+			defer cancel()
+			_, locked = store.Lock(ctx, newID)
+			w.WriteHeader(http.StatusNoContent)
+		}, WithLocking(true))
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			serve(t, h, &http.Cookie{Name: "sid", Value: encodeSessionId(data.ID, "secret")})
+		}()
+
+		moved := data
+		moved.ID = newID
+		require.NoError(t, store.Set(context.Background(), moved))
+		require.NoError(t, store.Set(context.Background(), newRedirect(data.ID, newID, time.Now().Add(time.Minute))))
+		require.NoError(t, unlock())
+		<-done
+
+		assert.ErrorIs(t, locked, context.DeadlineExceeded)
+	})
+
+	t.Run("should skip locking for bypassed requests", func(t *testing.T) {
+		store := NewMemoryStore()
+		data := storedSession(t, store, time.Hour)
+		unlock, err := store.Lock(context.Background(), data.ID)
+		require.NoError(t, err)
+		defer unlock()
+
+		h := newHandler(store, noContent,
+			WithLocking(true),
+			WithLockTimeout(10*time.Millisecond),
+			WithLockBypass(func(r *http.Request) bool { return r.Method == http.MethodGet }),
+		)
+
+		w := serve(t, h, &http.Cookie{Name: "sid", Value: encodeSessionId(data.ID, "secret")})
+		assert.Equal(t, http.StatusNoContent, w.Code)
+	})
+
+	t.Run("should panic when the store cannot lock", func(t *testing.T) {
+		assert.Panics(t, func() {
+			Handler(WithStore(NewCookieStore(NewKeyring(Key{Secret: []byte("secret")}), 0)), WithLocking(true))
+		})
+	})
+}
+
+func TestMiddleware_OptimisticConcurrency(t *testing.T) {
+	// concurrentWrite saves a change to the stored session behind the back
+	// of the request that loaded it.
+	concurrentWrite := func(t *testing.T, store Store, id, key string, value any) {
+		t.Helper()
+
+		stored, err := store.Get(context.Background(), id)
+		require.NoError(t, err)
+		stored.Data = maps.Clone(stored.Data)
+		stored.Data[key] = value
+		require.NoError(t, store.(VersionedStore).CompareAndSet(context.Background(), stored))
+	}
+
+	t.Run("should merge changes made concurrently", func(t *testing.T) {
+		store := NewMemoryStore()
+		data := storedSession(t, store, time.Hour)
+
+		h := newHandler(store, withSession(func(s *Session) {
+			s.Set("mine", "a")
+			concurrentWrite(t, store, data.ID, "theirs", "b")
+		}), WithOptimisticConcurrency(true))
+
+		w := serve(t, h, &http.Cookie{Name: "sid", Value: encodeSessionId(data.ID, "secret")})
+		assert.Equal(t, http.StatusNoContent, w.Code)
+
+		stored, err := store.Get(context.Background(), data.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "a", stored.Data["mine"])
+		assert.Equal(t, "b", stored.Data["theirs"])
+		assert.Equal(t, int64(2), stored.Version)
+	})
+
+	t.Run("should give up after the configured retries", func(t *testing.T) {
+		store := &conflictingStore{Store: NewMemoryStore()}
+		data := storedSession(t, store, time.Hour)
+
+		var got error
+		h := newHandler(store, withSession(func(s *Session) { s.Set("key", "value") }),
+			WithOptimisticConcurrency(true),
+			WithConcurrencyRetries(2),
+			WithErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+				got = err
+				w.WriteHeader(http.StatusConflict)
+			}),
+		)
+
+		w := serve(t, h, &http.Cookie{Name: "sid", Value: encodeSessionId(data.ID, "secret")})
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.ErrorIs(t, got, ErrConcurrentModification)
+		assert.Equal(t, 3, store.attempts)
+	})
+
+	t.Run("should panic when the store is not versioned", func(t *testing.T) {
+		assert.Panics(t, func() {
+			Handler(WithStore(NewCookieStore(NewKeyring(Key{Secret: []byte("secret")}), 0)), WithOptimisticConcurrency(true))
+		})
+	})
+}
+
+// conflictingStore fails every compare-and-set.
+type conflictingStore struct {
+	Store
+	attempts int
+}
+
+func (s *conflictingStore) CompareAndSet(context.Context, SessionData) error {
+	s.attempts++
+	return ErrConcurrentModification
+}
+
+func TestMiddleware_PartialWrites(t *testing.T) {
+	t.Run("should send only the changes of a stored session", func(t *testing.T) {
+		store := &countingPartialStore{countingStore: &countingStore{Store: NewMemoryStore()}}
+		data := storedSession(t, store, time.Hour)
+		store.sets = 0
+
+		h := newHandler(store, withSession(func(s *Session) { s.Set("key", "value") }))
+		serve(t, h, &http.Cookie{Name: "sid", Value: encodeSessionId(data.ID, "secret")})
+
+		assert.Equal(t, 0, store.sets)
+		assert.Equal(t, 1, store.updates)
+		stored, err := store.Get(context.Background(), data.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "value", stored.Data["key"])
+	})
+
+	t.Run("should keep keys written by a concurrent request", func(t *testing.T) {
+		store := NewMemoryStore()
+		data := storedSession(t, store, time.Hour)
+		c := &http.Cookie{Name: "sid", Value: encodeSessionId(data.ID, "secret")}
+
+		h := newHandler(store, withSession(func(s *Session) {
+			s.Set("mine", "a")
+
+			other := data
+			other.Data = map[string]any{"theirs": "b"}
+			require.NoError(t, store.Update(context.Background(), other, Changes{Added: other.Data}))
+		}))
+		serve(t, h, c)
+
+		stored, err := store.Get(context.Background(), data.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "a", stored.Data["mine"])
+		assert.Equal(t, "b", stored.Data["theirs"])
+	})
+
+	t.Run("should write sessions the store cannot patch in full", func(t *testing.T) {
+		store := &countingPartialStore{countingStore: &countingStore{Store: NewMemoryStore()}, unpatchable: true}
+		data := storedSession(t, store, time.Hour)
+		store.sets = 0
+
+		h := newHandler(store, withSession(func(s *Session) { s.Set("key", "value") }))
+		serve(t, h, &http.Cookie{Name: "sid", Value: encodeSessionId(data.ID, "secret")})
+
+		assert.Equal(t, 1, store.updates)
+		assert.Equal(t, 1, store.sets)
+		stored, err := store.Get(context.Background(), data.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "value", stored.Data["key"])
+	})
+
+	t.Run("should not bring back sessions deleted during the request", func(t *testing.T) {
+		store := &countingPartialStore{countingStore: &countingStore{Store: NewMemoryStore()}}
+		data := storedSession(t, store, time.Hour)
+		store.sets = 0
+
+		h := newHandler(store, withSession(func(s *Session) {
+			require.NoError(t, store.Delete(context.Background(), data.ID))
+			s.Set("key", "value")
+		}))
+		w := serve(t, h, &http.Cookie{Name: "sid", Value: encodeSessionId(data.ID, "secret")})
+
+		assert.Equal(t, 0, store.sets)
+		_, err := store.Get(context.Background(), data.ID)
+		assert.ErrorIs(t, err, ErrSessionNotFound)
+
+		c := responseCookie(w, "sid")
+		require.NotNil(t, c)
+		assert.Equal(t, -1, c.MaxAge)
+	})
+
+	t.Run("should write new sessions in full", func(t *testing.T) {
+		store := &countingPartialStore{countingStore: &countingStore{Store: NewMemoryStore()}}
+
+		h := newHandler(store, func(w http.ResponseWriter, r *http.Request) {
+			GetOrCreate(r.Context(), time.Hour).Set("key", "value")
+			w.WriteHeader(http.StatusNoContent)
+		})
+		serve(t, h)
+
+		assert.Equal(t, 1, store.sets)
+		assert.Equal(t, 0, store.updates)
+	})
+}
+
+// slowIndexStore widens the window between counting a user's sessions and
+// saving a new one.
+type slowIndexStore struct {
+	*MemoryStore
+}
+
+func (s slowIndexStore) ListByUser(ctx context.Context, userID string) ([]SessionData, error) {
+	sessions, err := s.MemoryStore.ListByUser(ctx, userID)
+	time.Sleep(5 * time.Millisecond)
+	return sessions, err
+}
+
+// scopeRecordingStore records the LockScope of each Lock call.
+type scopeRecordingStore struct {
+	*MemoryStore
+	scopes []*LockScope
+}
+
+func (s *scopeRecordingStore) Lock(ctx context.Context, id string) (func() error, error) {
+	scope, _ := LockScopeFromContext(ctx)
+	s.scopes = append(s.scopes, scope)
+	return s.MemoryStore.Lock(ctx, id)
+}
+
+// versionedPartialStore adds compare-and-set to a countingPartialStore.
+type versionedPartialStore struct {
+	*countingPartialStore
+	versioned VersionedStore
+}
+
+func (s versionedPartialStore) CompareAndSet(ctx context.Context, session SessionData) error {
+	return s.versioned.CompareAndSet(ctx, session)
+}
+
+// countingPartialStore records partial writes on top of countingStore.
+// With unpatchable set, every Update reports ErrPartialUpdateUnsupported.
+type countingPartialStore struct {
+	*countingStore
+	updates     int
+	unpatchable bool
+}
+
+func (s *countingPartialStore) Update(ctx context.Context, session SessionData, changes Changes) error {
+	s.updates++
+	if s.unpatchable {
+		return ErrPartialUpdateUnsupported
+	}
+	return s.Store.(PartialStore).Update(ctx, session, changes)
+}
@@ -0,0 +1,2 @@
+-- Absolute session lifetime (WithAbsoluteTimeout).
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS absolute_expires_at TIMESTAMP WITH TIME ZONE;
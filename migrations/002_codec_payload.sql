@@ -0,0 +1,3 @@
+-- Pluggable codecs (WithCodec). Existing rows keep their data as JSON.
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS codec VARCHAR(16) NOT NULL DEFAULT 'json';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS payload BYTEA;
@@ -0,0 +1,5 @@
+-- Client metadata (WithTrackMetadata).
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS ip VARCHAR(45);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS user_agent TEXT;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS device VARCHAR(255);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP WITH TIME ZONE;
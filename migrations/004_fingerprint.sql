@@ -0,0 +1,2 @@
+-- Session binding (WithBinding).
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS fingerprint VARCHAR(64);
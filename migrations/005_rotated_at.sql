@@ -0,0 +1,2 @@
+-- Session ID rotation (WithRotationInterval).
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS rotated_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,2 @@
+-- Optimistic concurrency (WithOptimisticConcurrency).
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 0;
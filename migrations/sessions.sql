@@ -0,0 +1,27 @@
+-- Schema for new installations of the postgres and pgx session stores.
+-- Existing tables are brought up to date by the numbered files in this
+-- directory, applied in order.
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id VARCHAR(48) PRIMARY KEY,
+    user_id VARCHAR(255),
+    authenticated BOOLEAN NOT NULL DEFAULT FALSE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    absolute_expires_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    data JSONB NOT NULL DEFAULT '{}'::jsonb,
+    codec VARCHAR(16) NOT NULL DEFAULT 'json',
+    payload BYTEA,
+    ip VARCHAR(45),
+    user_agent TEXT,
+    device VARCHAR(255),
+    last_seen_at TIMESTAMP WITH TIME ZONE,
+    fingerprint VARCHAR(64),
+    rotated_at TIMESTAMP WITH TIME ZONE,
+    version BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id) WHERE user_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_sessions_authenticated ON sessions(authenticated) WHERE authenticated = TRUE;
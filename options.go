@@ -12,14 +12,42 @@ type Options struct {
 	Store             Store
 	SaveUninitialized bool
 	AutoRenew         bool
+	RenewThreshold    float64
 	Secret            string
+	Keyring           *Keyring
+	EncryptCookie     bool
 	CookieName        string
 	Path              string
 	HTTPOnly          bool
 	Secure            bool
 	SameSite          http.SameSite
 	TTL               time.Duration
+	IdleTimeout       time.Duration
+	AbsoluteTimeout   time.Duration
+	CookieChunkSize   int
+	Transport         Transport
 	ErrorHandler      ErrorHandler
+
+	MaxSessionsPerUser int
+	SessionLimitPolicy SessionLimitPolicy
+
+	TrackMetadata  bool
+	TrustedProxies []string
+
+	Binding           BindingMode
+	Fingerprint       FingerprintFunc
+	OnBindingMismatch BindingMismatchHook
+
+	RegenerateOnAuth bool
+	RotationInterval time.Duration
+	RegenerateGrace  time.Duration
+
+	LockSessions bool
+	LockTimeout  time.Duration
+	LockBypass   func(r *http.Request) bool
+
+	OptimisticConcurrency bool
+	ConcurrencyRetries    int
 }
 
 func WithLogger(logger Logger) func(*Options) {
@@ -46,6 +74,24 @@ func WithSecret(secret string) func(*Options) {
 	}
 }
 
+// WithKeyring signs cookies with the keyring's active key and accepts
+// cookies signed with any of its previous keys. It takes precedence over
+// WithSecret.
+func WithKeyring(keyring *Keyring) func(*Options) {
+	return func(o *Options) {
+		o.Keyring = keyring
+	}
+}
+
+// WithEncryptedCookie seals the session ID and its issue time with the
+// keyring instead of only signing it, so the store key is never visible to
+// the client.
+func WithEncryptedCookie(encrypt bool) func(*Options) {
+	return func(o *Options) {
+		o.EncryptCookie = encrypt
+	}
+}
+
 func WithTTL(ttl time.Duration) func(*Options) {
 	return func(o *Options) {
 		o.TTL = ttl
@@ -93,3 +139,181 @@ func WithErrorHandler(errorHandler ErrorHandler) func(*Options) {
 		o.ErrorHandler = errorHandler
 	}
 }
+
+// WithCookieChunkSize splits cookie values longer than size bytes across
+// numbered cookies (sid.0, sid.1, ...), at most 16 of them. A size of zero
+// or less disables chunking; a NewCookieStore then needs a maxSize of at
+// most 4096, the limit browsers apply to one cookie.
+func WithCookieChunkSize(size int) func(*Options) {
+	return func(o *Options) {
+		o.CookieChunkSize = size
+	}
+}
+
+// WithTransport replaces the cookie built from the cookie options with a
+// custom transport, for example a header or a chain of transports.
+func WithTransport(transport Transport) func(*Options) {
+	return func(o *Options) {
+		o.Transport = transport
+	}
+}
+
+// WithIdleTimeout expires sessions that see no request for timeout. Each
+// request slides the expiry forward by timeout instead of by the TTL.
+func WithIdleTimeout(timeout time.Duration) func(*Options) {
+	return func(o *Options) {
+		o.IdleTimeout = timeout
+	}
+}
+
+// WithAbsoluteTimeout caps the lifetime of a session at timeout after its
+// creation, regardless of renewals. Sessions stored before the timeout was
+// set get the same deadline, counted from their creation, when next loaded.
+func WithAbsoluteTimeout(timeout time.Duration) func(*Options) {
+	return func(o *Options) {
+		o.AbsoluteTimeout = timeout
+	}
+}
+
+// WithRenewThreshold only renews a session once less than threshold of the
+// renewal window (TTL or idle timeout) remains, e.g. 0.5 renews in the
+// second half of the window. Zero renews on every request.
+func WithRenewThreshold(threshold float64) func(*Options) {
+	return func(o *Options) {
+		o.RenewThreshold = threshold
+	}
+}
+
+// WithMaxSessionsPerUser caps the number of active sessions a user can hold.
+// The store must implement UserIndex. Zero means no limit. When the store
+// also implements Locker, logins of the same user are serialized from the
+// count to the save; otherwise the limit is best-effort and concurrent
+// logins can briefly exceed it.
+func WithMaxSessionsPerUser(limit int) func(*Options) {
+	return func(o *Options) {
+		o.MaxSessionsPerUser = limit
+	}
+}
+
+// WithSessionLimitPolicy sets what happens when a login would exceed
+// MaxSessionsPerUser. Defaults to EvictOldest.
+func WithSessionLimitPolicy(policy SessionLimitPolicy) func(*Options) {
+	return func(o *Options) {
+		o.SessionLimitPolicy = policy
+	}
+}
+
+// WithTrackMetadata records the client IP, User-Agent, a device label and
+// the last request time on each session.
+func WithTrackMetadata(track bool) func(*Options) {
+	return func(o *Options) {
+		o.TrackMetadata = track
+	}
+}
+
+// WithTrustedProxies lists the proxies, as CIDR ranges or addresses, whose
+// X-Forwarded-For header is trusted when resolving the client IP.
+func WithTrustedProxies(proxies ...string) func(*Options) {
+	return func(o *Options) {
+		o.TrustedProxies = proxies
+	}
+}
+
+// WithBinding binds each session to the fingerprint of the request that
+// created or authenticated it and checks it on later requests according to
+// mode. Authenticated sessions stored without a fingerprint, e.g. before
+// binding was enabled, count as a mismatch.
+func WithBinding(mode BindingMode) func(*Options) {
+	return func(o *Options) {
+		o.Binding = mode
+	}
+}
+
+// WithFingerprint sets what sessions are bound to. Defaults to
+// UserAgentFingerprint.
+func WithFingerprint(fingerprint FingerprintFunc) func(*Options) {
+	return func(o *Options) {
+		o.Fingerprint = fingerprint
+	}
+}
+
+// WithBindingMismatchHook is called whenever a request does not match the
+// fingerprint of its session, e.g. to report it to a fraud service.
+func WithBindingMismatchHook(hook BindingMismatchHook) func(*Options) {
+	return func(o *Options) {
+		o.OnBindingMismatch = hook
+	}
+}
+
+// WithRegenerateOnAuth gives a session a new ID whenever Authenticate or
+// Unauthenticate changes who it belongs to, preventing session fixation.
+func WithRegenerateOnAuth(regenerate bool) func(*Options) {
+	return func(o *Options) {
+		o.RegenerateOnAuth = regenerate
+	}
+}
+
+// WithRotationInterval gives a session a new ID once its current ID is
+// older than interval. Zero disables rotation.
+func WithRotationInterval(interval time.Duration) func(*Options) {
+	return func(o *Options) {
+		o.RotationInterval = interval
+	}
+}
+
+// WithRegenerateGrace keeps the previous ID of a regenerated session valid
+// for grace so requests already in flight with the old cookie still
+// succeed. The old ID is replaced with a redirect record that resolves to
+// the new session and the response re-issues the cookie. Zero deletes the
+// old session immediately. The grace period only applies to rotation and
+// manual Regenerate; an ID replaced because of a login or logout is always
+// deleted, so it cannot be used to reach the authenticated session.
+func WithRegenerateGrace(grace time.Duration) func(*Options) {
+	return func(o *Options) {
+		o.RegenerateGrace = grace
+	}
+}
+
+// WithLocking holds a per-session lock for the duration of each request so
+// concurrent requests for the same session cannot overwrite each other's
+// changes. The store must implement Locker.
+func WithLocking(lock bool) func(*Options) {
+	return func(o *Options) {
+		o.LockSessions = lock
+	}
+}
+
+// WithLockTimeout limits how long a request waits for the session lock
+// before failing with ErrLockTimeout. Zero or less means the default of 5
+// seconds.
+func WithLockTimeout(timeout time.Duration) func(*Options) {
+	return func(o *Options) {
+		o.LockTimeout = timeout
+	}
+}
+
+// WithLockBypass skips locking for requests matching bypass, typically
+// read-only routes.
+func WithLockBypass(bypass func(r *http.Request) bool) func(*Options) {
+	return func(o *Options) {
+		o.LockBypass = bypass
+	}
+}
+
+// WithOptimisticConcurrency saves sessions with compare-and-set on their
+// Version. On a conflict the session is reloaded, the keys changed during
+// the request are re-applied and the save is retried. The store must
+// implement VersionedStore.
+func WithOptimisticConcurrency(enabled bool) func(*Options) {
+	return func(o *Options) {
+		o.OptimisticConcurrency = enabled
+	}
+}
+
+// WithConcurrencyRetries sets how many times a conflicting save is retried
+// before commit fails with ErrConcurrentModification. Defaults to 3.
+func WithConcurrencyRetries(retries int) func(*Options) {
+	return func(o *Options) {
+		o.ConcurrencyRetries = retries
+	}
+}
@@ -163,3 +163,249 @@ func TestWithPath(t *testing.T) {
 		assert.Equal(t, val, opts.Path)
 	}
 }
+
+func TestWithKeyring(t *testing.T) {
+	t.Run("should set keyring", func(t *testing.T) {
+		opts := &session.Options{}
+		keyring := session.NewKeyring(session.Key{ID: "k1", Secret: []byte("secret")})
+
+		fn := session.WithKeyring(keyring)
+		fn(opts)
+
+		assert.Equal(t, keyring, opts.Keyring)
+	})
+}
+
+func TestWithEncryptedCookie(t *testing.T) {
+	t.Run("should set encrypt cookie", func(t *testing.T) {
+		opts := &session.Options{}
+
+		for _, val := range []bool{true, false} {
+			fn := session.WithEncryptedCookie(val)
+			fn(opts)
+			assert.Equal(t, val, opts.EncryptCookie)
+		}
+	})
+}
+
+func TestWithCookieChunkSize(t *testing.T) {
+	t.Run("should set cookie chunk size", func(t *testing.T) {
+		opts := &session.Options{}
+
+		for _, val := range []int{0, 1000, 3800} {
+			fn := session.WithCookieChunkSize(val)
+			fn(opts)
+			assert.Equal(t, val, opts.CookieChunkSize)
+		}
+	})
+}
+
+func TestWithTransport(t *testing.T) {
+	t.Run("should set transport", func(t *testing.T) {
+		opts := &session.Options{}
+		transport := session.NewHeaderTransport("X-Session-Token")
+
+		fn := session.WithTransport(transport)
+		fn(opts)
+
+		assert.Equal(t, transport, opts.Transport)
+	})
+}
+
+func TestWithIdleTimeout(t *testing.T) {
+	t.Run("should set idle timeout", func(t *testing.T) {
+		opts := &session.Options{}
+
+		fn := session.WithIdleTimeout(15 * time.Minute)
+		fn(opts)
+
+		assert.Equal(t, 15*time.Minute, opts.IdleTimeout)
+	})
+}
+
+func TestWithAbsoluteTimeout(t *testing.T) {
+	t.Run("should set absolute timeout", func(t *testing.T) {
+		opts := &session.Options{}
+
+		fn := session.WithAbsoluteTimeout(8 * time.Hour)
+		fn(opts)
+
+		assert.Equal(t, 8*time.Hour, opts.AbsoluteTimeout)
+	})
+}
+
+func TestWithRenewThreshold(t *testing.T) {
+	t.Run("should set renew threshold", func(t *testing.T) {
+		opts := &session.Options{}
+
+		fn := session.WithRenewThreshold(0.5)
+		fn(opts)
+
+		assert.Equal(t, 0.5, opts.RenewThreshold)
+	})
+}
+
+func TestWithMaxSessionsPerUser(t *testing.T) {
+	t.Run("should set max sessions per user", func(t *testing.T) {
+		opts := &session.Options{}
+
+		fn := session.WithMaxSessionsPerUser(3)
+		fn(opts)
+
+		assert.Equal(t, 3, opts.MaxSessionsPerUser)
+	})
+}
+
+func TestWithSessionLimitPolicy(t *testing.T) {
+	t.Run("should set session limit policy", func(t *testing.T) {
+		opts := &session.Options{}
+
+		fn := session.WithSessionLimitPolicy(session.RejectNewLogin)
+		fn(opts)
+
+		assert.NotNil(t, opts.SessionLimitPolicy)
+	})
+}
+
+func TestWithTrackMetadata(t *testing.T) {
+	t.Run("should enable metadata tracking", func(t *testing.T) {
+		opts := &session.Options{}
+
+		fn := session.WithTrackMetadata(true)
+		fn(opts)
+
+		assert.True(t, opts.TrackMetadata)
+	})
+}
+
+func TestWithTrustedProxies(t *testing.T) {
+	t.Run("should set trusted proxies", func(t *testing.T) {
+		opts := &session.Options{}
+
+		fn := session.WithTrustedProxies("10.0.0.0/8", "192.168.1.1")
+		fn(opts)
+
+		assert.Equal(t, []string{"10.0.0.0/8", "192.168.1.1"}, opts.TrustedProxies)
+	})
+}
+
+func TestWithBinding(t *testing.T) {
+	t.Run("should set binding mode", func(t *testing.T) {
+		opts := &session.Options{}
+
+		fn := session.WithBinding(session.BindingStrict)
+		fn(opts)
+
+		assert.Equal(t, session.BindingStrict, opts.Binding)
+	})
+}
+
+func TestWithFingerprint(t *testing.T) {
+	t.Run("should set fingerprint func", func(t *testing.T) {
+		opts := &session.Options{}
+
+		fn := session.WithFingerprint(session.UserAgentFingerprint)
+		fn(opts)
+
+		assert.NotNil(t, opts.Fingerprint)
+	})
+}
+
+func TestWithBindingMismatchHook(t *testing.T) {
+	t.Run("should set binding mismatch hook", func(t *testing.T) {
+		opts := &session.Options{}
+
+		fn := session.WithBindingMismatchHook(func(*http.Request, session.SessionData) {})
+		fn(opts)
+
+		assert.NotNil(t, opts.OnBindingMismatch)
+	})
+}
+
+func TestWithRegenerateOnAuth(t *testing.T) {
+	t.Run("should enable regeneration on auth changes", func(t *testing.T) {
+		opts := &session.Options{}
+
+		fn := session.WithRegenerateOnAuth(true)
+		fn(opts)
+
+		assert.True(t, opts.RegenerateOnAuth)
+	})
+}
+
+func TestWithRotationInterval(t *testing.T) {
+	t.Run("should set rotation interval", func(t *testing.T) {
+		opts := &session.Options{}
+
+		fn := session.WithRotationInterval(15 * time.Minute)
+		fn(opts)
+
+		assert.Equal(t, 15*time.Minute, opts.RotationInterval)
+	})
+}
+
+func TestWithRegenerateGrace(t *testing.T) {
+	t.Run("should set regenerate grace", func(t *testing.T) {
+		opts := &session.Options{}
+
+		fn := session.WithRegenerateGrace(10 * time.Second)
+		fn(opts)
+
+		assert.Equal(t, 10*time.Second, opts.RegenerateGrace)
+	})
+}
+
+func TestWithLocking(t *testing.T) {
+	t.Run("should enable locking", func(t *testing.T) {
+		opts := &session.Options{}
+
+		fn := session.WithLocking(true)
+		fn(opts)
+
+		assert.True(t, opts.LockSessions)
+	})
+}
+
+func TestWithLockTimeout(t *testing.T) {
+	t.Run("should set lock timeout", func(t *testing.T) {
+		opts := &session.Options{}
+
+		fn := session.WithLockTimeout(2 * time.Second)
+		fn(opts)
+
+		assert.Equal(t, 2*time.Second, opts.LockTimeout)
+	})
+}
+
+func TestWithLockBypass(t *testing.T) {
+	t.Run("should set lock bypass", func(t *testing.T) {
+		opts := &session.Options{}
+
+		fn := session.WithLockBypass(func(*http.Request) bool { return true })
+		fn(opts)
+
+		assert.NotNil(t, opts.LockBypass)
+	})
+}
+
+func TestWithOptimisticConcurrency(t *testing.T) {
+	t.Run("should enable optimistic concurrency", func(t *testing.T) {
+		opts := &session.Options{}
+
+		fn := session.WithOptimisticConcurrency(true)
+		fn(opts)
+
+		assert.True(t, opts.OptimisticConcurrency)
+	})
+}
+
+func TestWithConcurrencyRetries(t *testing.T) {
+	t.Run("should set concurrency retries", func(t *testing.T) {
+		opts := &session.Options{}
+
+		fn := session.WithConcurrencyRetries(5)
+		fn(opts)
+
+		assert.Equal(t, 5, opts.ConcurrencyRetries)
+	})
+}
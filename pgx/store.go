@@ -6,17 +6,21 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/BrunoTulio/session"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 // Store implements session.Store interface using PostgreSQL.
 //
 // IMPORTANT: Before using this store, you must create the sessions table.
-// Run the migration SQL from migrations/sessions.sql or use the CreateSessionsTable function.
+// Run the migration SQL from migrations/sessions.sql. Tables created by an
+// earlier version are upgraded by the numbered files in migrations/,
+// applied in order; each only adds what is missing.
 //
 // Required table schema:
 //
@@ -25,18 +29,47 @@ import (
 //	    user_id VARCHAR(255),
 //	    authenticated BOOLEAN NOT NULL DEFAULT FALSE,
 //	    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+//	    absolute_expires_at TIMESTAMP WITH TIME ZONE,
 //	    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
 //	    updated_at TIMESTAMP WITH TIME ZONE NOT NULL,
-//	    data JSONB NOT NULL DEFAULT '{}'::jsonb
+//	    data JSONB NOT NULL DEFAULT '{}'::jsonb,
+//	    codec VARCHAR(16) NOT NULL DEFAULT 'json',
+//	    payload BYTEA,
+//	    ip VARCHAR(45),
+//	    user_agent TEXT,
+//	    device VARCHAR(255),
+//	    last_seen_at TIMESTAMP WITH TIME ZONE,
+//	    fingerprint VARCHAR(64),
+//	    rotated_at TIMESTAMP WITH TIME ZONE,
+//	    version BIGINT NOT NULL DEFAULT 0
 //	);
 //
 //	CREATE INDEX idx_sessions_expires_at ON sessions(expires_at);
 //	CREATE INDEX idx_sessions_user_id ON sessions(user_id) WHERE user_id IS NOT NULL;
 //	CREATE INDEX idx_sessions_authenticated ON sessions(authenticated) WHERE authenticated = TRUE;
+//
+// With the default JSON codec the session data lives in the data column.
+// Other codecs store it in payload and record their name in codec, so rows
+// written before a codec switch stay readable.
 type Store struct {
 	db              DB
 	log             session.Logger
 	cleanerInterval time.Duration
+	codec           session.Codec
+	locks           lockSlots
+}
+
+type Option func(*Store)
+
+// WithCodec serializes session data with codec instead of JSON.
+func WithCodec(codec session.Codec) Option {
+	return func(s *Store) {
+		s.codec = codec
+	}
+}
+
+func (s *Store) Codec() session.Codec {
+	return s.codec
 }
 
 type DB interface {
@@ -45,52 +78,77 @@ type DB interface {
 	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
 }
 
-func (s *Store) Get(ctx context.Context, id string) (session.SessionData, error) {
-	const query = `  SELECT id, 
-           user_id,
-          authenticated,
-    data ,
-    expires_at ,
-    created_at ,
-    updated_at 
-       FROM sessions
-       WHERE id = $1 AND expires_at > NOW()`
+type beginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// pool is implemented by *pgxpool.Pool, whose size caps the locks held at
+// once.
+type pool interface {
+	Stat() *pgxpool.Stat
+}
 
+const (
+	lockQuery    = "SELECT pg_advisory_xact_lock(hashtextextended($1, 0))"
+	tryLockQuery = "SELECT pg_try_advisory_xact_lock(hashtextextended($1, 0))"
+)
+
+const selectColumns = `id, user_id, authenticated, data, codec, payload,
+       expires_at, absolute_expires_at, created_at, updated_at,
+       ip, user_agent, device, last_seen_at, fingerprint, rotated_at, version`
+
+// rowScanner is satisfied by both a single row and a row set.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSession(row rowScanner) (session.SessionData, error) {
 	var (
 		sessionIDRow     string
 		userIDRow        sql.NullString
 		authenticatedRow bool
 		dataJSON         []byte
+		codecRow         string
+		payloadRow       []byte
 		expiresAtRow     time.Time
+		absoluteRow      sql.NullTime
 		createdAtRow     time.Time
 		updatedAtRow     time.Time
+		ipRow            sql.NullString
+		userAgentRow     sql.NullString
+		deviceRow        sql.NullString
+		lastSeenAtRow    sql.NullTime
+		fingerprintRow   sql.NullString
+		rotatedAtRow     sql.NullTime
+		versionRow       int64
 	)
 
-	err := s.db.QueryRow(ctx, query, id).Scan(
+	err := row.Scan(
 		&sessionIDRow,
 		&userIDRow,
 		&authenticatedRow,
 		&dataJSON,
+		&codecRow,
+		&payloadRow,
 		&expiresAtRow,
+		&absoluteRow,
 		&createdAtRow,
 		&updatedAtRow,
+		&ipRow,
+		&userAgentRow,
+		&deviceRow,
+		&lastSeenAtRow,
+		&fingerprintRow,
+		&rotatedAtRow,
+		&versionRow,
 	)
-
-	if errors.Is(err, sql.ErrNoRows) {
-		return session.SessionData{}, session.ErrSessionNotFound
-	}
-
 	if err != nil {
-		return session.SessionData{}, fmt.Errorf("postgres get failed: %w", err)
+		return session.SessionData{}, err
 	}
 
-	var data map[string]any
-	if len(dataJSON) > 0 {
-		if err := json.Unmarshal(dataJSON, &data); err != nil {
-			return session.SessionData{}, fmt.Errorf("unmarshal data failed: %w", err)
-		}
-	} else {
-		data = make(map[string]any)
+	data, err := decodeData(codecRow, dataJSON, payloadRow)
+	if err != nil {
+		return session.SessionData{}, err
 	}
 
 	sess := session.SessionData{
@@ -101,47 +159,252 @@ func (s *Store) Get(ctx context.Context, id string) (session.SessionData, error)
 		ExpiresAt:     expiresAtRow,
 		CreatedAt:     createdAtRow,
 		UpdatedAt:     updatedAtRow,
+		IP:            ipRow.String,
+		UserAgent:     userAgentRow.String,
+		Device:        deviceRow.String,
+		Fingerprint:   fingerprintRow.String,
+		Version:       versionRow,
+	}
+	if absoluteRow.Valid {
+		sess.AbsoluteExpiresAt = absoluteRow.Time
+	}
+	if lastSeenAtRow.Valid {
+		sess.LastSeenAt = lastSeenAtRow.Time
+	}
+	if rotatedAtRow.Valid {
+		sess.RotatedAt = rotatedAtRow.Time
 	}
 
 	return sess, nil
 }
 
-func (s *Store) Set(ctx context.Context, session session.SessionData) error {
-	dataJSON, err := json.Marshal(session.Data)
+func (s *Store) Get(ctx context.Context, id string) (session.SessionData, error) {
+	const query = "SELECT " + selectColumns + " FROM sessions WHERE id = $1 AND expires_at > NOW()"
+
+	sess, err := scanSession(s.db.QueryRow(ctx, query, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return session.SessionData{}, session.ErrSessionNotFound
+	}
+
 	if err != nil {
-		return fmt.Errorf("marshal data failed: %w", err)
+		return session.SessionData{}, fmt.Errorf("postgres get failed: %w", err)
 	}
 
-	const query = `
-       INSERT INTO sessions (id, user_id, authenticated, data, expires_at, created_at, updated_at)
-       VALUES ($1, $2, $3, $4, $5, $6, $7)
+	return sess, nil
+}
+
+const upsertQuery = `
+       INSERT INTO sessions (id, user_id, authenticated, data, codec, payload, expires_at, absolute_expires_at, created_at, updated_at,
+                             ip, user_agent, device, last_seen_at, fingerprint, rotated_at, version)
+       VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
        ON CONFLICT (id) 
        DO UPDATE SET 
           user_id = EXCLUDED.user_id,
           authenticated = EXCLUDED.authenticated,
           data = EXCLUDED.data,
+          codec = EXCLUDED.codec,
+          payload = EXCLUDED.payload,
           expires_at = EXCLUDED.expires_at,
-          updated_at = EXCLUDED.updated_at
+          absolute_expires_at = EXCLUDED.absolute_expires_at,
+          updated_at = EXCLUDED.updated_at,
+          ip = EXCLUDED.ip,
+          user_agent = EXCLUDED.user_agent,
+          device = EXCLUDED.device,
+          last_seen_at = EXCLUDED.last_seen_at,
+          fingerprint = EXCLUDED.fingerprint,
+          rotated_at = EXCLUDED.rotated_at,
+          version = EXCLUDED.version
     `
 
-	userID := sql.NullString{
-		String: session.UserID,
-		Valid:  session.UserID != "",
+const updateVersionQuery = `
+       UPDATE sessions SET
+          user_id = $2,
+          authenticated = $3,
+          data = $4,
+          codec = $5,
+          payload = $6,
+          expires_at = $7,
+          absolute_expires_at = $8,
+          updated_at = $9,
+          ip = $10,
+          user_agent = $11,
+          device = $12,
+          last_seen_at = $13,
+          fingerprint = $14,
+          rotated_at = $15,
+          version = $16
+       WHERE id = $1 AND version = $17
+    `
+
+func (s *Store) Set(ctx context.Context, session session.SessionData) error {
+	args, err := s.args(session, session.Version)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(ctx, upsertQuery, args...)
+	if err != nil {
+		return fmt.Errorf("insert/update failed: %w", err)
 	}
 
-	_, err = s.db.Exec(
-		ctx,
-		query,
+	return nil
+}
+
+// CompareAndSet saves sess only if the stored version still equals
+// sess.Version, writing it with the next version. A new session (version
+// zero) must not exist yet, or exist with version zero.
+func (s *Store) CompareAndSet(ctx context.Context, sess session.SessionData) error {
+	args, err := s.args(sess, sess.Version+1)
+	if err != nil {
+		return err
+	}
+
+	query := upsertQuery + " WHERE sessions.version = 0"
+	if sess.Version > 0 {
+		// The update leaves created_at alone, and Postgres rejects a
+		// parameter the statement does not use.
+		query = updateVersionQuery
+		args = append(slices.Delete(args, createdAtArg, createdAtArg+1), sess.Version)
+	}
+
+	result, err := s.db.Exec(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("compare and set failed: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return session.ErrConcurrentModification
+	}
+
+	return nil
+}
+
+// createdAtArg is the index of created_at in the parameters returned by args.
+const createdAtArg = 8
+
+// args returns the parameters of upsertQuery. updateVersionQuery takes the
+// same without created_at, followed by the expected version.
+func (s *Store) args(session session.SessionData, version int64) ([]any, error) {
+	dataJSON, payload, err := s.encodeData(session.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	return []any{
 		session.ID,
-		userID,
+		nullString(session.UserID),
 		session.Authenticated,
 		dataJSON,
+		s.codec.Name(),
+		payload,
 		session.ExpiresAt,
+		nullTime(session.AbsoluteExpiresAt),
 		session.CreatedAt,
 		session.UpdatedAt,
-	)
+		nullString(session.IP),
+		nullString(session.UserAgent),
+		nullString(session.Device),
+		nullTime(session.LastSeenAt),
+		nullString(session.Fingerprint),
+		nullTime(session.RotatedAt),
+		version,
+	}, nil
+}
+
+// partialUpdateQuery removes the deleted keys ($4, a JSON array) from the
+// data column and merges in the upserted ones ($5, a JSON object). It only
+// matches rows stored with the JSON codec, and reports whether it updated
+// the row and whether the row exists at all.
+const partialUpdateQuery = `
+       WITH updated AS (
+       UPDATE sessions SET
+          user_id = $2,
+          authenticated = $3,
+          data = (data - ARRAY(SELECT jsonb_array_elements_text($4::jsonb))) || $5::jsonb,
+          expires_at = $6,
+          absolute_expires_at = $7,
+          updated_at = $8,
+          ip = $9,
+          user_agent = $10,
+          device = $11,
+          last_seen_at = $12,
+          fingerprint = $13,
+          rotated_at = $14
+       WHERE id = $1 AND codec = 'json' AND expires_at > NOW()
+       RETURNING 1
+       )
+       SELECT EXISTS (SELECT 1 FROM updated),
+              EXISTS (SELECT 1 FROM sessions WHERE id = $1 AND expires_at > NOW())
+    `
+
+// Update applies only the changed keys to the data column. It returns
+// session.ErrPartialUpdateUnsupported when the store uses another codec or
+// the row was written with one, so the caller rewrites it in full.
+func (s *Store) Update(ctx context.Context, sess session.SessionData, changes session.Changes) error {
+	if s.codec.Name() != session.JSONCodec.Name() {
+		return session.ErrPartialUpdateUnsupported
+	}
+
+	// A nil slice would marshal to a JSON null, which cannot be expanded.
+	deletedKeys := changes.Deleted
+	if deletedKeys == nil {
+		deletedKeys = []string{}
+	}
+
+	deleted, err := json.Marshal(deletedKeys)
 	if err != nil {
-		return fmt.Errorf("insert/update failed: %w", err)
+		return fmt.Errorf("marshal data failed: %w", err)
+	}
+
+	upserts, err := json.Marshal(changes.Upserts())
+	if err != nil {
+		return fmt.Errorf("marshal data failed: %w", err)
+	}
+
+	var updated, exists bool
+	err = s.db.QueryRow(
+		ctx,
+		partialUpdateQuery,
+		sess.ID,
+		nullString(sess.UserID),
+		sess.Authenticated,
+		string(deleted),
+		string(upserts),
+		sess.ExpiresAt,
+		nullTime(sess.AbsoluteExpiresAt),
+		sess.UpdatedAt,
+		nullString(sess.IP),
+		nullString(sess.UserAgent),
+		nullString(sess.Device),
+		nullTime(sess.LastSeenAt),
+		nullString(sess.Fingerprint),
+		nullTime(sess.RotatedAt),
+	).Scan(&updated, &exists)
+	if err != nil {
+		return fmt.Errorf("partial update failed: %w", err)
+	}
+
+	switch {
+	case updated:
+		return nil
+	case exists:
+		return session.ErrPartialUpdateUnsupported
+	default:
+		return session.ErrSessionNotFound
+	}
+}
+
+// Touch moves expires_at without rewriting the session data.
+func (s *Store) Touch(ctx context.Context, id string, expiresAt time.Time) error {
+	const query = "UPDATE sessions SET expires_at = $2 WHERE id = $1 AND expires_at > NOW()"
+
+	result, err := s.db.Exec(ctx, query, id, expiresAt)
+	if err != nil {
+		return fmt.Errorf("touch failed: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return session.ErrSessionNotFound
 	}
 
 	return nil
@@ -158,6 +421,188 @@ func (s *Store) Delete(ctx context.Context, id string) error {
 	return nil
 }
 
+// ListByUser returns the live sessions of userID, oldest first.
+func (s *Store) ListByUser(ctx context.Context, userID string) ([]session.SessionData, error) {
+	const query = "SELECT " + selectColumns + ` FROM sessions
+       WHERE user_id = $1 AND expires_at > NOW()
+         AND (absolute_expires_at IS NULL OR absolute_expires_at > NOW())
+       ORDER BY created_at`
+
+	rows, err := s.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list by user failed: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []session.SessionData
+	for rows.Next() {
+		sess, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("list by user failed: %w", err)
+		}
+		sessions = append(sessions, sess)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list by user failed: %w", err)
+	}
+
+	return sessions, nil
+}
+
+func (s *Store) CountByUser(ctx context.Context, userID string) (int, error) {
+	const query = `SELECT COUNT(*) FROM sessions
+       WHERE user_id = $1 AND expires_at > NOW()
+         AND (absolute_expires_at IS NULL OR absolute_expires_at > NOW())`
+
+	var count int
+	if err := s.db.QueryRow(ctx, query, userID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("count by user failed: %w", err)
+	}
+
+	return count, nil
+}
+
+func (s *Store) DeleteByUser(ctx context.Context, userID string) error {
+	const query = "DELETE FROM sessions WHERE user_id = $1"
+
+	if _, err := s.db.Exec(ctx, query, userID); err != nil {
+		return fmt.Errorf("delete by user failed: %w", err)
+	}
+
+	return nil
+}
+
+// Lock takes a transaction-scoped advisory lock on the session ID. The
+// transaction, and with it a pooled connection, is held until unlock, while
+// the holder's Get and Set run on other connections of the pool. The DB
+// must be a *pgxpool.Pool: the store holds at most MaxConns-1 locks at once
+// and further Lock calls wait for one to be released, so a lock holder
+// always finds a connection for its queries.
+//
+// A further Lock with the session.LockScope of a held lock, such as the
+// user lock a login takes, is taken on the same transaction and needs no
+// connection of its own. It is held until the first lock is released.
+func (s *Store) Lock(ctx context.Context, id string) (func() error, error) {
+	scope, scoped := session.LockScopeFromContext(ctx)
+	if scoped {
+		if tx, ok := scope.Load(s); ok {
+			return lockOn(ctx, tx.(pgx.Tx), id)
+		}
+	}
+
+	p, ok := s.db.(pool)
+	if !ok {
+		return nil, errors.New("lock failed: db is not a connection pool")
+	}
+	limit := int(p.Stat().MaxConns()) - 1
+	if limit < 1 {
+		return nil, errors.New("lock failed: the pool needs at least two connections")
+	}
+	if err := s.locks.acquire(ctx, limit); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.(beginner).Begin(context.WithoutCancel(ctx))
+	if err != nil {
+		s.locks.release()
+		return nil, fmt.Errorf("lock failed: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, lockQuery, id); err != nil {
+		_ = tx.Rollback(context.Background())
+		s.locks.release()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("lock failed: %w", err)
+	}
+
+	if scoped {
+		scope.Store(s, tx)
+	}
+
+	return func() error {
+		defer s.locks.release()
+		if scoped {
+			scope.Delete(s)
+		}
+		return tx.Rollback(context.Background())
+	}, nil
+}
+
+// lockOn takes a lock on tx, the transaction of a lock the caller already
+// holds. It polls rather than blocks, since canceling a statement would
+// abort tx and so release the held lock too.
+func lockOn(ctx context.Context, tx pgx.Tx, id string) (func() error, error) {
+	wait := lockRetryMin
+	for {
+		var ok bool
+		if err := tx.QueryRow(context.WithoutCancel(ctx), tryLockQuery, id).Scan(&ok); err != nil {
+			return nil, fmt.Errorf("lock failed: %w", err)
+		}
+		if ok {
+			// Transaction-scoped, so it goes when tx ends.
+			return func() error { return nil }, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		wait = min(wait*2, lockRetryMax)
+	}
+}
+
+// encodeData returns the value for the data column and, for codecs other
+// than JSON, the payload column.
+func (s *Store) encodeData(data map[string]any) ([]byte, []byte, error) {
+	if s.codec.Name() == session.JSONCodec.Name() {
+		dataJSON, err := json.Marshal(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("marshal data failed: %w", err)
+		}
+		return dataJSON, nil, nil
+	}
+
+	payload, err := s.codec.Marshal(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("marshal data failed: %w", err)
+	}
+	return []byte("{}"), payload, nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+func decodeData(codecName string, dataJSON, payload []byte) (map[string]any, error) {
+	var data map[string]any
+
+	if codecName != "" && codecName != session.JSONCodec.Name() {
+		codec, ok := session.LookupCodec(codecName)
+		if !ok {
+			return nil, fmt.Errorf("unknown codec %q", codecName)
+		}
+		if err := codec.Unmarshal(payload, &data); err != nil {
+			return nil, fmt.Errorf("unmarshal data failed: %w", err)
+		}
+	} else if len(dataJSON) > 0 {
+		if err := json.Unmarshal(dataJSON, &data); err != nil {
+			return nil, fmt.Errorf("unmarshal data failed: %w", err)
+		}
+	}
+
+	if data == nil {
+		data = make(map[string]any)
+	}
+	return data, nil
+}
+
 func (s *Store) cleanExpiredSessions(interval time.Duration) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
@@ -182,11 +627,16 @@ func (s *Store) cleanExpiredSessions(interval time.Duration) {
 	}
 }
 
-func New(db DB, log session.Logger, cleanerInterval time.Duration) session.Store {
+func New(db DB, log session.Logger, cleanerInterval time.Duration, opts ...Option) session.Store {
 	s := &Store{
 		db:              db,
 		log:             log,
 		cleanerInterval: cleanerInterval,
+		codec:           session.JSONCodec,
+	}
+
+	for _, o := range opts {
+		o(s)
 	}
 
 	go s.cleanExpiredSessions(cleanerInterval)
@@ -0,0 +1,123 @@
+package pgx_test
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	pgxstore "github.com/BrunoTulio/session/pgx"
+
+	"github.com/BrunoTulio/session"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+// call is a statement sent to recordingDB.
+type call struct {
+	query string
+	args  []any
+}
+
+// recordingDB records every statement instead of running it. Writes report
+// one affected row and reads find nothing.
+type recordingDB struct {
+	calls []call
+}
+
+func (db *recordingDB) Exec(_ context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	db.calls = append(db.calls, call{sql, args})
+	return pgconn.NewCommandTag("UPDATE 1"), nil
+}
+
+func (db *recordingDB) Query(_ context.Context, sql string, args ...any) (pgx.Rows, error) {
+	db.calls = append(db.calls, call{sql, args})
+	return nil, pgx.ErrNoRows
+}
+
+func (db *recordingDB) QueryRow(_ context.Context, sql string, args ...any) pgx.Row {
+	db.calls = append(db.calls, call{sql, args})
+	return noRow{}
+}
+
+type noRow struct{}
+
+func (noRow) Scan(...any) error {
+	return pgx.ErrNoRows
+}
+
+var placeholder = regexp.MustCompile(`\$(\d+)`)
+
+// assertPlaceholders checks that c uses each of $1..$n for its n arguments
+// and no other parameter. Postgres rejects unused parameters, since it cannot
+// infer their type.
+func assertPlaceholders(t *testing.T, c call) {
+	t.Helper()
+
+	used := make(map[int]bool)
+	for _, m := range placeholder.FindAllStringSubmatch(c.query, -1) {
+		n, _ := strconv.Atoi(m[1])
+		used[n] = true
+	}
+
+	for i := 1; i <= len(c.args); i++ {
+		assert.True(t, used[i], "$%d is passed but not used in %s", i, c.query)
+	}
+	for n := range used {
+		assert.LessOrEqual(t, n, len(c.args), "$%d is used but not passed in %s", n, c.query)
+	}
+}
+
+func TestPgxStore_Placeholders(t *testing.T) {
+	ctx := context.Background()
+	sess := session.SessionData{
+		ID:            "session-123",
+		UserID:        "user-1",
+		Authenticated: true,
+		Data:          map[string]any{"theme": "dark"},
+		ExpiresAt:     time.Now().Add(time.Hour),
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+	versioned := sess
+	versioned.Version = 1
+
+	ops := map[string]func(s session.Store){
+		"Set":                    func(s session.Store) { _ = s.Set(ctx, sess) },
+		"CompareAndSet new":      func(s session.Store) { _ = s.(session.VersionedStore).CompareAndSet(ctx, sess) },
+		"CompareAndSet existing": func(s session.Store) { _ = s.(session.VersionedStore).CompareAndSet(ctx, versioned) },
+		"Update": func(s session.Store) {
+			_ = s.(session.PartialStore).Update(ctx, sess, session.Changes{Deleted: []string{"a"}})
+		},
+		"Touch":        func(s session.Store) { _ = s.(session.Toucher).Touch(ctx, sess.ID, sess.ExpiresAt) },
+		"Get":          func(s session.Store) { _, _ = s.Get(ctx, sess.ID) },
+		"Delete":       func(s session.Store) { _ = s.Delete(ctx, sess.ID) },
+		"ListByUser":   func(s session.Store) { _, _ = s.(session.UserIndex).ListByUser(ctx, sess.UserID) },
+		"CountByUser":  func(s session.Store) { _, _ = s.(session.UserIndex).CountByUser(ctx, sess.UserID) },
+		"DeleteByUser": func(s session.Store) { _ = s.(session.UserIndex).DeleteByUser(ctx, sess.UserID) },
+	}
+
+	for name, op := range ops {
+		t.Run("should pass one argument per parameter to "+name, func(t *testing.T) {
+			db := &recordingDB{}
+			op(pgxstore.New(db, nil, time.Hour))
+
+			assert.NotEmpty(t, db.calls)
+			for _, c := range db.calls {
+				assertPlaceholders(t, c)
+			}
+		})
+	}
+
+	t.Run("should pass the expected version last", func(t *testing.T) {
+		db := &recordingDB{}
+		store := pgxstore.New(db, nil, time.Hour).(session.VersionedStore)
+
+		assert.NoError(t, store.CompareAndSet(ctx, versioned))
+		args := db.calls[0].args
+		assert.Equal(t, versioned.Version+1, args[len(args)-2])
+		assert.Equal(t, versioned.Version, args[len(args)-1])
+	})
+}
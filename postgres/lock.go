@@ -0,0 +1,57 @@
+package postgres
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Polling bounds for a lock taken on the transaction of a held one.
+const (
+	lockRetryMin = 10 * time.Millisecond
+	lockRetryMax = 200 * time.Millisecond
+)
+
+// lockSlots counts the connections held by session locks. Capping them
+// below the pool size leaves a connection free for the queries the lock
+// holders run, which would otherwise wait for each other forever.
+type lockSlots struct {
+	mu    sync.Mutex
+	held  int
+	freed chan struct{}
+}
+
+// acquire waits until fewer than limit locks are held, or ctx is done. A
+// limit of zero or less means no cap.
+func (l *lockSlots) acquire(ctx context.Context, limit int) error {
+	for {
+		l.mu.Lock()
+		if limit <= 0 || l.held < limit {
+			l.held++
+			l.mu.Unlock()
+			return nil
+		}
+		if l.freed == nil {
+			l.freed = make(chan struct{})
+		}
+		freed := l.freed
+		l.mu.Unlock()
+
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (l *lockSlots) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.held--
+	if l.freed != nil {
+		close(l.freed)
+		l.freed = nil
+	}
+}
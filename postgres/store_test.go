@@ -0,0 +1,223 @@
+package postgres_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	pgstore "github.com/BrunoTulio/session/postgres"
+
+	"github.com/BrunoTulio/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// call is a statement sent to recordingConn.
+type call struct {
+	query string
+	args  []any
+}
+
+// recordingConnector opens connections that record every statement instead
+// of running it. Writes report one affected row, advisory lock attempts
+// succeed and other reads find nothing.
+type recordingConnector struct {
+	calls []call
+}
+
+func (c *recordingConnector) Connect(context.Context) (driver.Conn, error) {
+	return &recordingConn{connector: c}, nil
+}
+
+func (c *recordingConnector) Driver() driver.Driver {
+	return nil
+}
+
+type recordingConn struct {
+	connector *recordingConnector
+}
+
+func (c *recordingConn) record(query string, named []driver.NamedValue) {
+	args := make([]any, len(named))
+	for i, v := range named {
+		args[i] = v.Value
+	}
+	c.connector.calls = append(c.connector.calls, call{query, args})
+}
+
+func (c *recordingConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.record(query, args)
+	return driver.RowsAffected(1), nil
+}
+
+func (c *recordingConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.record(query, args)
+	if strings.Contains(query, "pg_try_advisory_xact_lock") {
+		return &boolRows{value: true}, nil
+	}
+	return noRows{}, nil
+}
+
+func (c *recordingConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepare is not supported")
+}
+
+func (c *recordingConn) Begin() (driver.Tx, error) {
+	return nopTx{}, nil
+}
+
+func (c *recordingConn) Close() error {
+	return nil
+}
+
+type noRows struct{}
+
+func (noRows) Columns() []string         { return nil }
+func (noRows) Close() error              { return nil }
+func (noRows) Next([]driver.Value) error { return io.EOF }
+
+// boolRows is a single row of one boolean column.
+type boolRows struct {
+	value bool
+	done  bool
+}
+
+func (r *boolRows) Columns() []string { return []string{"ok"} }
+func (r *boolRows) Close() error      { return nil }
+
+func (r *boolRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.value
+	return nil
+}
+
+type nopTx struct{}
+
+func (nopTx) Commit() error   { return nil }
+func (nopTx) Rollback() error { return nil }
+
+var placeholder = regexp.MustCompile(`\$(\d+)`)
+
+// assertPlaceholders checks that c uses each of $1..$n for its n arguments
+// and no other parameter. Postgres rejects unused parameters, since it cannot
+// infer their type.
+func assertPlaceholders(t *testing.T, c call) {
+	t.Helper()
+
+	used := make(map[int]bool)
+	for _, m := range placeholder.FindAllStringSubmatch(c.query, -1) {
+		n, _ := strconv.Atoi(m[1])
+		used[n] = true
+	}
+
+	for i := 1; i <= len(c.args); i++ {
+		assert.True(t, used[i], "$%d is passed but not used in %s", i, c.query)
+	}
+	for n := range used {
+		assert.LessOrEqual(t, n, len(c.args), "$%d is used but not passed in %s", n, c.query)
+	}
+}
+
+func TestPostgresStore_Placeholders(t *testing.T) {
+	ctx := context.Background()
+	sess := session.SessionData{
+		ID:            "session-123",
+		UserID:        "user-1",
+		Authenticated: true,
+		Data:          map[string]any{"theme": "dark"},
+		ExpiresAt:     time.Now().Add(time.Hour),
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+	versioned := sess
+	versioned.Version = 1
+
+	ops := map[string]func(s session.Store){
+		"Set":                    func(s session.Store) { _ = s.Set(ctx, sess) },
+		"CompareAndSet new":      func(s session.Store) { _ = s.(session.VersionedStore).CompareAndSet(ctx, sess) },
+		"CompareAndSet existing": func(s session.Store) { _ = s.(session.VersionedStore).CompareAndSet(ctx, versioned) },
+		"Update": func(s session.Store) {
+			_ = s.(session.PartialStore).Update(ctx, sess, session.Changes{Deleted: []string{"a"}})
+		},
+		"Touch":        func(s session.Store) { _ = s.(session.Toucher).Touch(ctx, sess.ID, sess.ExpiresAt) },
+		"Get":          func(s session.Store) { _, _ = s.Get(ctx, sess.ID) },
+		"Delete":       func(s session.Store) { _ = s.Delete(ctx, sess.ID) },
+		"ListByUser":   func(s session.Store) { _, _ = s.(session.UserIndex).ListByUser(ctx, sess.UserID) },
+		"CountByUser":  func(s session.Store) { _, _ = s.(session.UserIndex).CountByUser(ctx, sess.UserID) },
+		"DeleteByUser": func(s session.Store) { _ = s.(session.UserIndex).DeleteByUser(ctx, sess.UserID) },
+	}
+
+	for name, op := range ops {
+		t.Run("should pass one argument per parameter to "+name, func(t *testing.T) {
+			connector := &recordingConnector{}
+			db := sql.OpenDB(connector)
+			defer db.Close()
+			op(pgstore.New(db, nil, time.Hour))
+
+			assert.NotEmpty(t, connector.calls)
+			for _, c := range connector.calls {
+				assertPlaceholders(t, c)
+			}
+		})
+	}
+
+	t.Run("should pass the expected version last", func(t *testing.T) {
+		connector := &recordingConnector{}
+		db := sql.OpenDB(connector)
+		defer db.Close()
+		store := pgstore.New(db, nil, time.Hour).(session.VersionedStore)
+
+		assert.NoError(t, store.CompareAndSet(ctx, versioned))
+		args := connector.calls[0].args
+		assert.Equal(t, versioned.Version+1, args[len(args)-2])
+		assert.Equal(t, versioned.Version, args[len(args)-1])
+	})
+}
+
+func TestPostgresStore_Lock(t *testing.T) {
+	newLocker := func(t *testing.T) session.Locker {
+		db := sql.OpenDB(&recordingConnector{})
+		t.Cleanup(func() { _ = db.Close() })
+		db.SetMaxOpenConns(2)
+		return pgstore.New(db, nil, time.Hour).(session.Locker)
+	}
+
+	t.Run("should take a second lock of the same scope on the held transaction", func(t *testing.T) {
+		locker := newLocker(t)
+		ctx := session.WithLockScope(context.Background())
+
+		unlock, err := locker.Lock(ctx, "session-123")
+		require.NoError(t, err)
+
+		lockCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		unlockUser, err := locker.Lock(lockCtx, "user:user-1")
+		require.NoError(t, err)
+
+		assert.NoError(t, unlockUser())
+		assert.NoError(t, unlock())
+	})
+
+	t.Run("should keep a connection free for locks of other scopes", func(t *testing.T) {
+		locker := newLocker(t)
+
+		unlock, err := locker.Lock(session.WithLockScope(context.Background()), "session-123")
+		require.NoError(t, err)
+		defer unlock()
+
+		ctx, cancel := context.WithTimeout(session.WithLockScope(context.Background()), 50*time.Millisecond)
+		defer cancel()
+		_, err = locker.Lock(ctx, "session-456")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
@@ -0,0 +1,37 @@
+package session
+
+import "time"
+
+const (
+	// redirectKey is the Data key of a redirect record. It is reserved and
+	// never present on a live session.
+	redirectKey = "_session_moved_to"
+
+	// maxRedirects bounds how many redirects a lookup follows, for IDs that
+	// were regenerated more than once within the grace period.
+	maxRedirects = 3
+)
+
+// newRedirect builds the record stored under the old ID of a regenerated
+// session. It points at the new ID until expiresAt and carries no user so
+// it never shows up in a UserIndex.
+func newRedirect(oldID, newID string, expiresAt time.Time) SessionData {
+	t := now()
+	return SessionData{
+		ID:        oldID,
+		Data:      map[string]any{redirectKey: newID},
+		CreatedAt: t,
+		UpdatedAt: t,
+		ExpiresAt: expiresAt,
+	}
+}
+
+// redirectTarget returns the new ID if data is a redirect record.
+func redirectTarget(data SessionData) (string, bool) {
+	if len(data.Data) != 1 || data.Authenticated {
+		return "", false
+	}
+
+	newID, ok := data.Data[redirectKey].(string)
+	return newID, ok && newID != ""
+}
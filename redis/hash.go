@@ -0,0 +1,172 @@
+package redis
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BrunoTulio/session"
+	"github.com/redis/go-redis/v9"
+)
+
+// Layout selects how a session is stored under its key.
+type Layout int
+
+const (
+	// StringLayout stores each session as one encoded string. It is the
+	// default.
+	StringLayout Layout = iota
+	// HashLayout stores each session as a hash: one plain-text field per
+	// metadata attribute ("user_id", "authenticated", "expires_at", ...)
+	// and one "data:<key>" field per Data key, encoded with the store codec.
+	// Changed keys are written without rewriting the session, and single
+	// fields can be read with HGET or from Lua.
+	HashLayout
+)
+
+func otherLayout(layout Layout) Layout {
+	if layout == HashLayout {
+		return StringLayout
+	}
+	return HashLayout
+}
+
+// Hash layout fields. Times are RFC 3339 with nanoseconds, empty when zero.
+const (
+	fieldID                = "id"
+	fieldUserID            = "user_id"
+	fieldAuthenticated     = "authenticated"
+	fieldCreatedAt         = "created_at"
+	fieldExpiresAt         = "expires_at"
+	fieldAbsoluteExpiresAt = "absolute_expires_at"
+	fieldRotatedAt         = "rotated_at"
+	fieldUpdatedAt         = "updated_at"
+	fieldIP                = "ip"
+	fieldUserAgent         = "user_agent"
+	fieldDevice            = "device"
+	fieldLastSeenAt        = "last_seen_at"
+	fieldVersion           = "version"
+	fieldFingerprint       = "fingerprint"
+
+	dataFieldPrefix = "data:"
+)
+
+// updateScript applies a partial write to a session stored as a hash.
+// ARGV holds the TTL in milliseconds, the number of fields to delete, those
+// fields, then field/value pairs to set. It returns 0 without writing when
+// the key is missing and -1 when it uses the string layout.
+var updateScript = redis.NewScript(`
+local t = redis.call("TYPE", KEYS[1]).ok
+if t == "none" then
+	return 0
+elseif t ~= "hash" then
+	return -1
+end
+local deleted = tonumber(ARGV[2])
+for i = 3, deleted + 2 do
+	redis.call("HDEL", KEYS[1], ARGV[i])
+end
+for i = deleted + 3, #ARGV, 2 do
+	redis.call("HSET", KEYS[1], ARGV[i], ARGV[i + 1])
+end
+redis.call("PEXPIRE", KEYS[1], ARGV[1])
+return 1
+`)
+
+// metadataFields returns every metadata field of sess. Zero values are
+// written as empty strings, so a partial write can overwrite them.
+func metadataFields(sess session.SessionData) map[string]any {
+	return map[string]any{
+		fieldID:                sess.ID,
+		fieldUserID:            sess.UserID,
+		fieldAuthenticated:     strconv.FormatBool(sess.Authenticated),
+		fieldCreatedAt:         formatTime(sess.CreatedAt),
+		fieldExpiresAt:         formatTime(sess.ExpiresAt),
+		fieldAbsoluteExpiresAt: formatTime(sess.AbsoluteExpiresAt),
+		fieldRotatedAt:         formatTime(sess.RotatedAt),
+		fieldUpdatedAt:         formatTime(sess.UpdatedAt),
+		fieldIP:                sess.IP,
+		fieldUserAgent:         sess.UserAgent,
+		fieldDevice:            sess.Device,
+		fieldLastSeenAt:        formatTime(sess.LastSeenAt),
+		fieldVersion:           strconv.FormatInt(sess.Version, 10),
+		fieldFingerprint:       sess.Fingerprint,
+	}
+}
+
+// encodeHash returns the metadata fields of sess plus a field for each of
+// data.
+func (s *Store) encodeHash(sess session.SessionData, data map[string]any) (map[string]any, error) {
+	fields := metadataFields(sess)
+	for k, v := range data {
+		encoded, err := session.EncodePayload(s.codec, &v)
+		if err != nil {
+			return nil, fmt.Errorf("data key %q: %w", k, err)
+		}
+		fields[dataFieldPrefix+k] = encoded
+	}
+	return fields, nil
+}
+
+func decodeHash(fields map[string]string) (session.SessionData, error) {
+	sess := session.SessionData{
+		ID:          fields[fieldID],
+		UserID:      fields[fieldUserID],
+		IP:          fields[fieldIP],
+		UserAgent:   fields[fieldUserAgent],
+		Device:      fields[fieldDevice],
+		Fingerprint: fields[fieldFingerprint],
+		Data:        make(map[string]any),
+	}
+
+	var err error
+	if v := fields[fieldAuthenticated]; v != "" {
+		if sess.Authenticated, err = strconv.ParseBool(v); err != nil {
+			return session.SessionData{}, fmt.Errorf("field %s: %w", fieldAuthenticated, err)
+		}
+	}
+	if v := fields[fieldVersion]; v != "" {
+		if sess.Version, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return session.SessionData{}, fmt.Errorf("field %s: %w", fieldVersion, err)
+		}
+	}
+
+	times := map[string]*time.Time{
+		fieldCreatedAt:         &sess.CreatedAt,
+		fieldExpiresAt:         &sess.ExpiresAt,
+		fieldAbsoluteExpiresAt: &sess.AbsoluteExpiresAt,
+		fieldRotatedAt:         &sess.RotatedAt,
+		fieldUpdatedAt:         &sess.UpdatedAt,
+		fieldLastSeenAt:        &sess.LastSeenAt,
+	}
+	for field, t := range times {
+		if v := fields[field]; v != "" {
+			if *t, err = time.Parse(time.RFC3339Nano, v); err != nil {
+				return session.SessionData{}, fmt.Errorf("field %s: %w", field, err)
+			}
+		}
+	}
+
+	for field, raw := range fields {
+		key, ok := strings.CutPrefix(field, dataFieldPrefix)
+		if !ok {
+			continue
+		}
+
+		var v any
+		if err := session.DecodePayload([]byte(raw), &v); err != nil {
+			return session.SessionData{}, fmt.Errorf("data key %q: %w", key, err)
+		}
+		sess.Data[key] = v
+	}
+
+	return sess, nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
@@ -2,64 +2,644 @@ package redis
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/rand"
 	"errors"
 	"fmt"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/BrunoTulio/session"
 	"github.com/redis/go-redis/v9"
 )
 
+const (
+	minTTL = time.Second
+
+	defaultLockTTL = 30 * time.Second
+	lockRetryMin   = 10 * time.Millisecond
+	lockRetryMax   = 200 * time.Millisecond
+)
+
+// unlockScript deletes a lock only if it still holds the caller's token,
+// so an expired lock taken over by another request is left alone.
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// renewScript resets the TTL of a lock only if it still holds the caller's
+// token. ARGV holds the token and the TTL in milliseconds.
+var renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// indexScript adds ARGV[1] to the user index in KEYS[1] and extends the
+// index TTL to ARGV[2] milliseconds unless it already lives longer, which
+// EXPIRE NX and GT would need Redis 7 for.
+var indexScript = redis.NewScript(`
+redis.call("SADD", KEYS[1], ARGV[1])
+if redis.call("PTTL", KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 1
+`)
+
 type Store struct {
-	prefix string
-	client *redis.Client
+	prefix   string
+	client   redis.UniversalClient
+	codec    session.Codec
+	layout   Layout
+	lockTTL  time.Duration
+	hashTags bool
+	cluster  bool
+}
+
+type Option func(*Store)
+
+// WithCodec serializes sessions with codec instead of JSON. Sessions written
+// with a previous codec remain readable.
+func WithCodec(codec session.Codec) Option {
+	return func(s *Store) {
+		s.codec = codec
+	}
+}
+
+// WithLayout sets how sessions are written. Reads use the command of this
+// layout, but sessions stored in the other one are still readable with a
+// second round trip, so the layout can be switched on a live deployment;
+// each session moves over on its next full write.
+func WithLayout(layout Layout) Option {
+	return func(s *Store) {
+		s.layout = layout
+	}
+}
+
+// WithLockTTL sets how long a session lock outlives the process holding it,
+// in case it dies without releasing it. A held lock is renewed every third
+// of the TTL, so requests may run longer. Defaults to 30 seconds.
+func WithLockTTL(ttl time.Duration) Option {
+	return func(s *Store) {
+		s.lockTTL = ttl
+	}
 }
 
-func NewStore(client *redis.Client, prefix string) session.Store {
-	return &Store{
-		prefix: prefix,
-		client: client,
+// WithHashTags wraps session IDs and user IDs in keys in a hash tag, as in
+// "session:{id}" and "session:lock:{id}", so a session's keys share a
+// Redis Cluster slot. It is on by default for a *redis.ClusterClient.
+// Changing it changes every key name, so existing sessions are lost.
+func WithHashTags(enabled bool) Option {
+	return func(s *Store) {
+		s.hashTags = enabled
+	}
+}
+
+// NewStore creates a store on any go-redis client: a single node
+// (*redis.Client), Sentinel (redis.NewFailoverClient) or Redis Cluster
+// (*redis.ClusterClient).
+//
+// On a cluster the session key and the user index live in different
+// slots, so the index is written after the session rather than in the same
+// transaction, and the index is only eventually consistent with the
+// sessions: a session whose index write failed, or was not yet made, is
+// missing from ListByUser, CountByUser and DeleteByUser, so a session limit
+// can briefly be exceeded and a logout everywhere can miss it. ListByUser
+// prunes index members whose session is gone or changed owner. On a single
+// node or Sentinel both are written in one transaction.
+func NewStore(client redis.UniversalClient, prefix string, opts ...Option) session.Store {
+	_, cluster := client.(*redis.ClusterClient)
+
+	s := &Store{
+		prefix:   prefix,
+		client:   client,
+		codec:    session.JSONCodec,
+		lockTTL:  defaultLockTTL,
+		hashTags: cluster,
+		cluster:  cluster,
+	}
+
+	for _, o := range opts {
+		o(s)
 	}
+
+	return s
+}
+
+func (s *Store) Codec() session.Codec {
+	return s.codec
 }
 
 func (s *Store) Get(ctx context.Context, id string) (session.SessionData, error) {
-	key := s.prefix + id
+	sessions, errs, err := s.fetch(ctx, []string{id})
+	if err != nil {
+		return session.SessionData{}, err
+	}
 
-	data, err := s.client.Get(ctx, key).Bytes()
-	if errors.Is(err, redis.Nil) {
-		return session.SessionData{}, session.ErrSessionNotFound
+	return sessions[0], errs[0]
+}
+
+// errWrongLayout is returned by decode when the key holds a session in the
+// layout other than the one it was read with.
+var errWrongLayout = errors.New("session stored in the other layout")
+
+// fetch reads the sessions ids in one pipeline with the command of the
+// configured layout. Sessions still stored in the other layout fail with
+// WRONGTYPE and are read again with its command in a second round trip, so
+// only a deployment in the middle of a layout switch pays for it.
+func (s *Store) fetch(ctx context.Context, ids []string) ([]session.SessionData, []error, error) {
+	sessions := make([]session.SessionData, len(ids))
+	errs := make([]error, len(ids))
+
+	pending := make([]int, len(ids))
+	for i := range ids {
+		pending[i] = i
+	}
+
+	for _, layout := range []Layout{s.layout, otherLayout(s.layout)} {
+		if len(pending) == 0 {
+			break
+		}
+
+		cmds := make([]getCmds, len(pending))
+		_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for j, i := range pending {
+				cmds[j] = s.queueGet(ctx, pipe, ids[i], layout)
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, redis.Nil) && !isWrongType(err) {
+			return nil, nil, fmt.Errorf("redis get failed: %w", err)
+		}
+
+		var retry []int
+		for j, i := range pending {
+			sessions[i], errs[i] = s.decode(cmds[j])
+			if errors.Is(errs[i], errWrongLayout) {
+				retry = append(retry, i)
+			}
+		}
+		pending = retry
 	}
 
+	return sessions, errs, nil
+}
+
+// getCmds reads a session in one layout: GET for StringLayout, HGETALL
+// for HashLayout.
+type getCmds struct {
+	get  *redis.StringCmd
+	hash *redis.MapStringStringCmd
+	pttl *redis.DurationCmd
+}
+
+func (s *Store) queueGet(ctx context.Context, pipe redis.Pipeliner, id string, layout Layout) getCmds {
+	key := s.key(id)
+
+	var cmds getCmds
+	if layout == HashLayout {
+		cmds.hash = pipe.HGetAll(ctx, key)
+	} else {
+		cmds.get = pipe.Get(ctx, key)
+	}
+	cmds.pttl = pipe.PTTL(ctx, key)
+	return cmds
+}
+
+func isWrongType(err error) bool {
+	return redis.HasErrorPrefix(err, "WRONGTYPE")
+}
+
+func (s *Store) decode(cmds getCmds) (session.SessionData, error) {
+	var (
+		sess session.SessionData
+		err  error
+	)
+	if cmds.hash != nil {
+		sess, err = decodeHashCmd(cmds.hash)
+	} else {
+		sess, err = decodeStringCmd(cmds.get)
+	}
 	if err != nil {
+		return session.SessionData{}, err
+	}
+
+	// Touch only moves the key TTL, so a TTL beyond the stored expiry means
+	// the session was extended after it was written.
+	if ttl := cmds.pttl.Val(); ttl > minTTL {
+		if expiresAt := time.Now().Add(ttl); expiresAt.After(sess.ExpiresAt) {
+			sess.ExpiresAt = expiresAt
+		}
+	}
+
+	return sess, nil
+}
+
+func decodeStringCmd(cmd *redis.StringCmd) (session.SessionData, error) {
+	data, err := cmd.Bytes()
+	switch {
+	case errors.Is(err, redis.Nil):
+		return session.SessionData{}, session.ErrSessionNotFound
+	case isWrongType(err):
+		return session.SessionData{}, fmt.Errorf("%w: %w", errWrongLayout, err)
+	case err != nil:
 		return session.SessionData{}, fmt.Errorf("redis get failed: %w", err)
 	}
 
 	var sess session.SessionData
-	if err := json.Unmarshal(data, &sess); err != nil {
+	if err := session.DecodePayload(data, &sess); err != nil {
 		return session.SessionData{}, fmt.Errorf("unmarshal failed: %w", err)
 	}
+	return sess, nil
+}
+
+func decodeHashCmd(cmd *redis.MapStringStringCmd) (session.SessionData, error) {
+	fields, err := cmd.Result()
+	switch {
+	case isWrongType(err):
+		return session.SessionData{}, fmt.Errorf("%w: %w", errWrongLayout, err)
+	case err != nil:
+		return session.SessionData{}, fmt.Errorf("redis hgetall failed: %w", err)
+	case len(fields) == 0:
+		return session.SessionData{}, session.ErrSessionNotFound
+	}
 
+	sess, err := decodeHash(fields)
+	if err != nil {
+		return session.SessionData{}, fmt.Errorf("unmarshal failed: %w", err)
+	}
 	return sess, nil
 }
 
-func (s *Store) Set(ctx context.Context, session session.SessionData) error {
-	key := s.prefix + session.ID
+func (s *Store) Set(ctx context.Context, sess session.SessionData) error {
+	p, err := s.encode(sess)
+	if err != nil {
+		return fmt.Errorf("marshal failed: %w", err)
+	}
 
-	data, err := json.Marshal(&session)
+	if sess.UserID == "" && s.layout == StringLayout {
+		return s.client.Set(ctx, s.key(sess.ID), p.data, s.ttl(sess)).Err()
+	}
+
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		s.queueSet(ctx, pipe, sess, p)
+		if !s.cluster {
+			s.queueIndex(ctx, pipe, sess)
+		}
+		return nil
+	})
+	if err != nil || !s.cluster {
+		return err
+	}
+
+	return s.index(ctx, sess)
+}
+
+// Update writes only the changed Data keys of a session stored in the hash
+// layout, together with its metadata. It returns
+// session.ErrPartialUpdateUnsupported for the string layout and for
+// sessions still stored as strings, so the caller rewrites them in full,
+// which also moves them to the configured layout.
+func (s *Store) Update(ctx context.Context, sess session.SessionData, changes session.Changes) error {
+	if s.layout != HashLayout {
+		return session.ErrPartialUpdateUnsupported
+	}
+
+	fields, err := s.encodeHash(sess, changes.Upserts())
 	if err != nil {
 		return fmt.Errorf("marshal failed: %w", err)
 	}
 
-	ttl := time.Until(session.ExpiresAt)
+	args := make([]any, 0, 2+len(changes.Deleted)+2*len(fields))
+	args = append(args, s.ttl(sess).Milliseconds(), len(changes.Deleted))
+	for _, k := range changes.Deleted {
+		args = append(args, dataFieldPrefix+k)
+	}
+	for field, v := range fields {
+		args = append(args, field, v)
+	}
+
+	updated, err := updateScript.Run(ctx, s.client, []string{s.key(sess.ID)}, args...).Int()
+	if err != nil {
+		return fmt.Errorf("redis update failed: %w", err)
+	}
+	switch updated {
+	case 0:
+		return session.ErrSessionNotFound
+	case -1:
+		return session.ErrPartialUpdateUnsupported
+	}
+
+	return s.index(ctx, sess)
+}
+
+// multi runs fn in a MULTI/EXEC transaction, or, on a cluster where the
+// keys may span slots, in a plain pipeline.
+func (s *Store) multi(ctx context.Context, fn func(redis.Pipeliner)) error {
+	queue := func(pipe redis.Pipeliner) error {
+		fn(pipe)
+		return nil
+	}
+
+	if s.cluster {
+		_, err := s.client.Pipelined(ctx, queue)
+		return err
+	}
+
+	_, err := s.client.TxPipelined(ctx, queue)
+	return err
+}
+
+// CompareAndSet saves sess only if the stored version still equals
+// sess.Version. The check and the write run under WATCH, so a write by
+// another client in between aborts the transaction.
+func (s *Store) CompareAndSet(ctx context.Context, sess session.SessionData) error {
+	key := s.key(sess.ID)
+
+	err := s.client.Watch(ctx, func(tx *redis.Tx) error {
+		current, err := s.Get(ctx, sess.ID)
+		if err != nil && !errors.Is(err, session.ErrSessionNotFound) {
+			return err
+		}
+		if current.Version != sess.Version {
+			return session.ErrConcurrentModification
+		}
+
+		next := sess
+		next.Version++
+		p, err := s.encode(next)
+		if err != nil {
+			return fmt.Errorf("marshal failed: %w", err)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			s.queueSet(ctx, pipe, next, p)
+			// The transaction is bound to the session's slot, so on a
+			// cluster the index is written once it has committed.
+			if !s.cluster {
+				s.queueIndex(ctx, pipe, next)
+			}
+			return nil
+		})
+		return err
+	}, key)
+
+	if errors.Is(err, redis.TxFailedErr) {
+		return session.ErrConcurrentModification
+	}
+	if err != nil || !s.cluster {
+		return err
+	}
+
+	return s.index(ctx, sess)
+}
+
+func (s *Store) ttl(sess session.SessionData) time.Duration {
+	ttl := time.Until(sess.ExpiresAt)
 	if ttl < 0 {
-		ttl = time.Second
+		ttl = minTTL
 	}
+	return ttl
+}
+
+// payload is an encoded session: data in the string layout, fields in the
+// hash layout.
+type payload struct {
+	data   []byte
+	fields map[string]any
+}
 
-	return s.client.Set(ctx, key, data, ttl).Err()
+func (s *Store) encode(sess session.SessionData) (payload, error) {
+	if s.layout == HashLayout {
+		fields, err := s.encodeHash(sess, sess.Data)
+		return payload{fields: fields}, err
+	}
+
+	data, err := session.EncodePayload(s.codec, &sess)
+	return payload{data: data}, err
+}
+
+// queueSet replaces the stored session. A hash is deleted first so that
+// removed Data keys and a key in the other layout do not survive.
+func (s *Store) queueSet(ctx context.Context, pipe redis.Pipeliner, sess session.SessionData, p payload) {
+	key := s.key(sess.ID)
+	ttl := s.ttl(sess)
+
+	if p.fields == nil {
+		pipe.Set(ctx, key, p.data, ttl)
+		return
+	}
+
+	pipe.Del(ctx, key)
+	pipe.HSet(ctx, key, p.fields)
+	pipe.PExpire(ctx, key, ttl)
+}
+
+// index writes the user index entry of sess on its own, for a cluster where
+// it cannot share the session's transaction.
+func (s *Store) index(ctx context.Context, sess session.SessionData) error {
+	if sess.UserID == "" {
+		return nil
+	}
+
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		s.queueIndex(ctx, pipe, sess)
+		return nil
+	})
+	return err
+}
+
+// queueIndex adds a session with a user to the user index and keeps the
+// index alive at least as long as the session.
+func (s *Store) queueIndex(ctx context.Context, pipe redis.Pipeliner, sess session.SessionData) {
+	if sess.UserID == "" {
+		return
+	}
+
+	// EVAL rather than EVALSHA: a pipeline cannot retry on NOSCRIPT.
+	indexScript.Eval(ctx, pipe, []string{s.userKey(sess.UserID)}, sess.ID, s.ttl(sess).Milliseconds())
 }
 
 func (s *Store) Delete(ctx context.Context, id string) error {
-	key := s.prefix + id
-	return s.client.Del(ctx, key).Err()
+	key := s.key(id)
+
+	sess, err := s.Get(ctx, id)
+	if err != nil || sess.UserID == "" {
+		return s.client.Del(ctx, key).Err()
+	}
+
+	return s.multi(ctx, func(pipe redis.Pipeliner) {
+		pipe.Del(ctx, key)
+		pipe.SRem(ctx, s.userKey(sess.UserID), id)
+	})
+}
+
+// Touch moves the key expiry without rewriting the stored session.
+func (s *Store) Touch(ctx context.Context, id string, expiresAt time.Time) error {
+	key := s.key(id)
+
+	ok, err := s.client.PExpireAt(ctx, key, expiresAt).Result()
+	if err != nil {
+		return fmt.Errorf("redis expire failed: %w", err)
+	}
+	if !ok {
+		return session.ErrSessionNotFound
+	}
+
+	return nil
+}
+
+// Lock takes the session lock with SET NX and a random token, polling with
+// backoff until it is free or ctx is done. The lock is renewed until it is
+// released.
+func (s *Store) Lock(ctx context.Context, id string) (func() error, error) {
+	key := s.prefix + "lock:" + s.tag(id)
+	token := rand.Text()
+
+	wait := lockRetryMin
+	for {
+		ok, err := s.client.SetNX(ctx, key, token, s.lockTTL).Result()
+		if err != nil {
+			return nil, fmt.Errorf("redis lock failed: %w", err)
+		}
+		if ok {
+			stop := make(chan struct{})
+			go s.renew(key, token, stop)
+
+			var once sync.Once
+			return func() error {
+				once.Do(func() { close(stop) })
+				return unlockScript.Run(context.Background(), s.client, []string{key}, token).Err()
+			}, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		wait = min(wait*2, lockRetryMax)
+	}
+}
+
+// renew resets the TTL of a held lock every third of it, until stop is
+// closed or the lock is lost to expiry or an error.
+func (s *Store) renew(key, token string, stop <-chan struct{}) {
+	if s.lockTTL <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.lockTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		held, err := renewScript.Run(context.Background(), s.client, []string{key}, token, s.lockTTL.Milliseconds()).Bool()
+		if err != nil || !held {
+			return
+		}
+	}
+}
+
+func (s *Store) key(id string) string {
+	return s.prefix + s.tag(id)
+}
+
+func (s *Store) userKey(userID string) string {
+	return s.prefix + "user:" + s.tag(userID)
+}
+
+// tag wraps part of a key in a hash tag, so Redis Cluster hashes only that
+// part when picking the slot.
+func (s *Store) tag(part string) string {
+	if !s.hashTags {
+		return part
+	}
+	return "{" + part + "}"
+}
+
+// ListByUser returns the live sessions of userID, oldest first. Members of
+// the user set whose session expired or now belongs to someone else are
+// pruned on the way. On a cluster the result is only eventually
+// consistent; see NewStore.
+func (s *Store) ListByUser(ctx context.Context, userID string) ([]session.SessionData, error) {
+	userKey := s.userKey(userID)
+
+	ids, err := s.client.SMembers(ctx, userKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis smembers failed: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	loaded, errs, err := s.fetch(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		sessions []session.SessionData
+		stale    []any
+	)
+	for i, id := range ids {
+		sess, err := loaded[i], errs[i]
+		if errors.Is(err, session.ErrSessionNotFound) || (err == nil && sess.UserID != userID) {
+			stale = append(stale, id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, sess)
+	}
+
+	if len(stale) > 0 {
+		if err := s.client.SRem(ctx, userKey, stale...).Err(); err != nil {
+			return nil, fmt.Errorf("redis srem failed: %w", err)
+		}
+	}
+
+	slices.SortFunc(sessions, func(a, b session.SessionData) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	return sessions, nil
+}
+
+func (s *Store) CountByUser(ctx context.Context, userID string) (int, error) {
+	sessions, err := s.ListByUser(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	return len(sessions), nil
+}
+
+func (s *Store) DeleteByUser(ctx context.Context, userID string) error {
+	sessions, err := s.ListByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, sess := range sessions {
+			pipe.Del(ctx, s.key(sess.ID))
+		}
+		pipe.Del(ctx, s.userKey(userID))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("redis delete failed: %w", err)
+	}
+
+	return nil
 }
@@ -5,6 +5,7 @@ package redis_test
 import (
 	"context"
 	"encoding/json"
+	"strings"
 	"testing"
 	"time"
 
@@ -476,3 +477,651 @@ func TestRedisStore_ContextCancellation(t *testing.T) {
 		assert.Error(t, err)
 	})
 }
+
+func TestRedisStore_Touch(t *testing.T) {
+	t.Run("should extend the key ttl and the loaded expiry", func(t *testing.T) {
+		client, mr := setupRedis(t)
+		store := redisstore.NewStore(client, "session:")
+		ctx := context.Background()
+
+		data := session.SessionData{
+			ID:        "touch",
+			Data:      map[string]any{"key": "value"},
+			CreatedAt: time.Now(),
+			ExpiresAt: time.Now().Add(1 * time.Hour),
+			UpdatedAt: time.Now(),
+		}
+		require.NoError(t, store.Set(ctx, data))
+		before, _ := mr.Get("session:touch")
+
+		expiresAt := time.Now().Add(3 * time.Hour)
+		err := store.(session.Toucher).Touch(ctx, "touch", expiresAt)
+		require.NoError(t, err)
+
+		after, _ := mr.Get("session:touch")
+		assert.Equal(t, before, after, "payload should not be rewritten")
+		assert.Greater(t, mr.TTL("session:touch").Seconds(), 3.0*3590)
+
+		retrieved, err := store.Get(ctx, "touch")
+		require.NoError(t, err)
+		assert.WithinDuration(t, expiresAt, retrieved.ExpiresAt, time.Second)
+	})
+
+	t.Run("should return not found for missing sessions", func(t *testing.T) {
+		client, _ := setupRedis(t)
+		store := redisstore.NewStore(client, "session:")
+
+		err := store.(session.Toucher).Touch(context.Background(), "missing", time.Now().Add(time.Hour))
+		assert.ErrorIs(t, err, session.ErrSessionNotFound)
+	})
+}
+
+func TestRedisStore_Codec(t *testing.T) {
+	t.Run("should store payloads tagged with the codec name", func(t *testing.T) {
+		client, mr := setupRedis(t)
+		store := redisstore.NewStore(client, "session:", redisstore.WithCodec(session.MsgpackCodec))
+		ctx := context.Background()
+
+		data := session.SessionData{
+			ID:        "codec",
+			Data:      map[string]any{"key": "value"},
+			CreatedAt: time.Now(),
+			ExpiresAt: time.Now().Add(1 * time.Hour),
+			UpdatedAt: time.Now(),
+		}
+		require.NoError(t, store.Set(ctx, data))
+
+		raw, err := mr.Get("session:codec")
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(raw, "msgpack:"))
+
+		retrieved, err := store.Get(ctx, "codec")
+		require.NoError(t, err)
+		assert.Equal(t, "value", retrieved.Data["key"])
+	})
+
+	t.Run("should read sessions written with a previous codec", func(t *testing.T) {
+		client, _ := setupRedis(t)
+		ctx := context.Background()
+
+		data := session.SessionData{
+			ID:        "switch",
+			Data:      map[string]any{"key": "value"},
+			CreatedAt: time.Now(),
+			ExpiresAt: time.Now().Add(1 * time.Hour),
+			UpdatedAt: time.Now(),
+		}
+		require.NoError(t, redisstore.NewStore(client, "session:").Set(ctx, data))
+
+		store := redisstore.NewStore(client, "session:", redisstore.WithCodec(session.CBORCodec))
+		retrieved, err := store.Get(ctx, "switch")
+		require.NoError(t, err)
+		assert.Equal(t, "value", retrieved.Data["key"])
+
+		require.NoError(t, store.Set(ctx, retrieved))
+		retrieved, err = redisstore.NewStore(client, "session:").Get(ctx, "switch")
+		require.NoError(t, err)
+		assert.Equal(t, "value", retrieved.Data["key"])
+	})
+
+	t.Run("should expose the codec", func(t *testing.T) {
+		client, _ := setupRedis(t)
+		store := redisstore.NewStore(client, "session:", redisstore.WithCodec(session.GobCodec))
+
+		assert.Equal(t, session.GobCodec, store.(session.CodecProvider).Codec())
+	})
+}
+
+func TestRedisStore_UserIndex(t *testing.T) {
+	newSession := func(id, userID string, createdAt time.Time) session.SessionData {
+		return session.SessionData{
+			ID:        id,
+			UserID:    userID,
+			Data:      map[string]any{},
+			CreatedAt: createdAt,
+			ExpiresAt: time.Now().Add(1 * time.Hour),
+			UpdatedAt: createdAt,
+		}
+	}
+
+	t.Run("should list sessions of a user oldest first", func(t *testing.T) {
+		client, mr := setupRedis(t)
+		store := redisstore.NewStore(client, "session:")
+		index := store.(session.UserIndex)
+		ctx := context.Background()
+
+		base := time.Now()
+		require.NoError(t, store.Set(ctx, newSession("b", "user-1", base.Add(time.Minute))))
+		require.NoError(t, store.Set(ctx, newSession("a", "user-1", base)))
+		require.NoError(t, store.Set(ctx, newSession("c", "user-2", base)))
+
+		sessions, err := index.ListByUser(ctx, "user-1")
+		require.NoError(t, err)
+		require.Len(t, sessions, 2)
+		assert.Equal(t, "a", sessions[0].ID)
+		assert.Equal(t, "b", sessions[1].ID)
+
+		assert.True(t, mr.Exists("session:user:user-1"))
+		assert.Greater(t, mr.TTL("session:user:user-1"), time.Duration(0))
+	})
+
+	t.Run("should keep the index as long as its longest session", func(t *testing.T) {
+		client, mr := setupRedis(t)
+		recorder := &commandRecorder{}
+		client.AddHook(recorder)
+		store := redisstore.NewStore(client, "session:")
+		ctx := context.Background()
+
+		long := newSession("a", "user-1", time.Now())
+		long.ExpiresAt = time.Now().Add(2 * time.Hour)
+		require.NoError(t, store.Set(ctx, long))
+		require.NoError(t, store.Set(ctx, newSession("b", "user-1", time.Now())))
+		assert.Greater(t, mr.TTL("session:user:user-1"), 90*time.Minute)
+
+		mr.FastForward(time.Hour + 30*time.Minute)
+		require.NoError(t, store.Set(ctx, newSession("c", "user-1", time.Now())))
+		assert.Greater(t, mr.TTL("session:user:user-1"), 50*time.Minute)
+
+		// EXPIRE with NX or GT needs Redis 7.
+		assert.NotContains(t, recorder.names, "expire")
+	})
+
+	t.Run("should remove deleted sessions from the index", func(t *testing.T) {
+		client, mr := setupRedis(t)
+		store := redisstore.NewStore(client, "session:")
+		ctx := context.Background()
+
+		require.NoError(t, store.Set(ctx, newSession("a", "user-1", time.Now())))
+		require.NoError(t, store.Set(ctx, newSession("b", "user-1", time.Now())))
+
+		require.NoError(t, store.Delete(ctx, "a"))
+
+		members, err := mr.Members("session:user:user-1")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"b"}, members)
+	})
+
+	t.Run("should prune expired sessions from the index", func(t *testing.T) {
+		client, mr := setupRedis(t)
+		store := redisstore.NewStore(client, "session:")
+		index := store.(session.UserIndex)
+		ctx := context.Background()
+
+		short := newSession("a", "user-1", time.Now())
+		short.ExpiresAt = time.Now().Add(2 * time.Second)
+		require.NoError(t, store.Set(ctx, short))
+		require.NoError(t, store.Set(ctx, newSession("b", "user-1", time.Now())))
+
+		mr.FastForward(3 * time.Second)
+
+		count, err := index.CountByUser(ctx, "user-1")
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+
+		members, err := mr.Members("session:user:user-1")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"b"}, members)
+	})
+
+	t.Run("should delete all sessions of a user", func(t *testing.T) {
+		client, mr := setupRedis(t)
+		store := redisstore.NewStore(client, "session:")
+		index := store.(session.UserIndex)
+		ctx := context.Background()
+
+		require.NoError(t, store.Set(ctx, newSession("a", "user-1", time.Now())))
+		require.NoError(t, store.Set(ctx, newSession("b", "user-1", time.Now())))
+		require.NoError(t, store.Set(ctx, newSession("c", "user-2", time.Now())))
+
+		require.NoError(t, index.DeleteByUser(ctx, "user-1"))
+
+		assert.False(t, mr.Exists("session:a"))
+		assert.False(t, mr.Exists("session:b"))
+		assert.False(t, mr.Exists("session:user:user-1"))
+		assert.True(t, mr.Exists("session:c"))
+	})
+}
+
+func TestRedisStore_Lock(t *testing.T) {
+	t.Run("should hold the lock until released", func(t *testing.T) {
+		client, mr := setupRedis(t)
+		locker := redisstore.NewStore(client, "session:").(session.Locker)
+
+		unlock, err := locker.Lock(context.Background(), "session-123")
+		require.NoError(t, err)
+		assert.True(t, mr.Exists("session:lock:session-123"))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err = locker.Lock(ctx, "session-123")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		require.NoError(t, unlock())
+		assert.False(t, mr.Exists("session:lock:session-123"))
+
+		unlock, err = locker.Lock(context.Background(), "session-123")
+		require.NoError(t, err)
+		assert.NoError(t, unlock())
+	})
+
+	t.Run("should expire abandoned locks", func(t *testing.T) {
+		client, mr := setupRedis(t)
+		locker := redisstore.NewStore(client, "session:", redisstore.WithLockTTL(time.Second)).(session.Locker)
+
+		_, err := locker.Lock(context.Background(), "session-123")
+		require.NoError(t, err)
+
+		mr.FastForward(2 * time.Second)
+
+		_, err = locker.Lock(context.Background(), "session-123")
+		assert.NoError(t, err)
+	})
+
+	t.Run("should renew the lock while it is held", func(t *testing.T) {
+		client, mr := setupRedis(t)
+		locker := redisstore.NewStore(client, "session:", redisstore.WithLockTTL(300*time.Millisecond)).(session.Locker)
+
+		unlock, err := locker.Lock(context.Background(), "session-123")
+		require.NoError(t, err)
+
+		mr.FastForward(250 * time.Millisecond)
+		assert.Eventually(t, func() bool {
+			return mr.TTL("session:lock:session-123") > 100*time.Millisecond
+		}, time.Second, 10*time.Millisecond)
+
+		require.NoError(t, unlock())
+		assert.False(t, mr.Exists("session:lock:session-123"))
+	})
+
+	t.Run("should not release a lock taken over by another holder", func(t *testing.T) {
+		client, mr := setupRedis(t)
+		locker := redisstore.NewStore(client, "session:", redisstore.WithLockTTL(time.Second)).(session.Locker)
+
+		stale, err := locker.Lock(context.Background(), "session-123")
+		require.NoError(t, err)
+
+		mr.FastForward(2 * time.Second)
+		_, err = locker.Lock(context.Background(), "session-123")
+		require.NoError(t, err)
+
+		require.NoError(t, stale())
+		assert.True(t, mr.Exists("session:lock:session-123"))
+	})
+}
+
+func TestRedisStore_CompareAndSet(t *testing.T) {
+	t.Run("should increment the version", func(t *testing.T) {
+		client, _ := setupRedis(t)
+		store := redisstore.NewStore(client, "session:")
+		versioned := store.(session.VersionedStore)
+		ctx := context.Background()
+
+		data := session.NewSessionData(time.Hour)
+		data.Authenticate("user-1")
+		require.NoError(t, versioned.CompareAndSet(ctx, data))
+
+		stored, err := store.Get(ctx, data.ID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), stored.Version)
+
+		count, err := store.(session.UserIndex).CountByUser(ctx, "user-1")
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("should reject stale versions", func(t *testing.T) {
+		client, _ := setupRedis(t)
+		versioned := redisstore.NewStore(client, "session:").(session.VersionedStore)
+		ctx := context.Background()
+
+		data := session.NewSessionData(time.Hour)
+		require.NoError(t, versioned.CompareAndSet(ctx, data))
+
+		err := versioned.CompareAndSet(ctx, data)
+		assert.ErrorIs(t, err, session.ErrConcurrentModification)
+	})
+}
+
+func setupCluster(t *testing.T) (*redis.ClusterClient, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+
+	client := redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs: []string{mr.Addr()},
+	})
+	t.Cleanup(func() { client.Close() })
+
+	return client, mr
+}
+
+func TestRedisStore_Cluster(t *testing.T) {
+	newSession := func(id, userID string) session.SessionData {
+		return session.SessionData{
+			ID:        id,
+			UserID:    userID,
+			Data:      map[string]any{"key": "value"},
+			CreatedAt: time.Now(),
+			ExpiresAt: time.Now().Add(1 * time.Hour),
+			UpdatedAt: time.Now(),
+		}
+	}
+
+	t.Run("should use hash tags for every key", func(t *testing.T) {
+		client, mr := setupCluster(t)
+		store := redisstore.NewStore(client, "session:")
+		ctx := context.Background()
+
+		require.NoError(t, store.Set(ctx, newSession("a", "user-1")))
+		assert.True(t, mr.Exists("session:{a}"))
+		assert.True(t, mr.Exists("session:user:{user-1}"))
+
+		unlock, err := store.(session.Locker).Lock(ctx, "a")
+		require.NoError(t, err)
+		assert.True(t, mr.Exists("session:lock:{a}"))
+		require.NoError(t, unlock())
+
+		got, err := store.Get(ctx, "a")
+		require.NoError(t, err)
+		assert.Equal(t, "value", got.Data["key"])
+	})
+
+	t.Run("should keep the user index across slots", func(t *testing.T) {
+		client, mr := setupCluster(t)
+		store := redisstore.NewStore(client, "session:")
+		index := store.(session.UserIndex)
+		ctx := context.Background()
+
+		require.NoError(t, store.Set(ctx, newSession("a", "user-1")))
+		require.NoError(t, store.Set(ctx, newSession("b", "user-1")))
+
+		count, err := index.CountByUser(ctx, "user-1")
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+
+		require.NoError(t, store.Delete(ctx, "a"))
+		members, err := mr.Members("session:user:{user-1}")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"b"}, members)
+
+		require.NoError(t, index.DeleteByUser(ctx, "user-1"))
+		assert.False(t, mr.Exists("session:{b}"))
+		assert.False(t, mr.Exists("session:user:{user-1}"))
+	})
+
+	t.Run("should compare and set within the session slot", func(t *testing.T) {
+		client, mr := setupCluster(t)
+		store := redisstore.NewStore(client, "session:")
+		versioned := store.(session.VersionedStore)
+		ctx := context.Background()
+
+		sess := newSession("a", "user-1")
+		require.NoError(t, versioned.CompareAndSet(ctx, sess))
+		assert.ErrorIs(t, versioned.CompareAndSet(ctx, sess), session.ErrConcurrentModification)
+
+		got, err := store.Get(ctx, "a")
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), got.Version)
+
+		members, err := mr.Members("session:user:{user-1}")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a"}, members)
+	})
+
+	t.Run("should write hashes within the session slot", func(t *testing.T) {
+		client, mr := setupCluster(t)
+		store := redisstore.NewStore(client, "session:", redisstore.WithLayout(redisstore.HashLayout))
+		ctx := context.Background()
+
+		sess := newSession("a", "user-1")
+		require.NoError(t, store.Set(ctx, sess))
+		require.NoError(t, store.(session.PartialStore).Update(ctx, sess, session.Changes{
+			Added: map[string]any{"other": "value"},
+		}))
+
+		assert.Equal(t, "user-1", mr.HGet("session:{a}", "user_id"))
+		assert.Equal(t, `"value"`, mr.HGet("session:{a}", "data:other"))
+		assert.True(t, mr.Exists("session:user:{user-1}"))
+	})
+}
+
+func TestRedisStore_UniversalClient(t *testing.T) {
+	t.Run("should accept a universal client", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		client := redis.NewUniversalClient(&redis.UniversalOptions{
+			Addrs: []string{mr.Addr()},
+		})
+		defer client.Close()
+
+		store := redisstore.NewStore(client, "session:")
+		ctx := context.Background()
+
+		data := session.NewSessionData(time.Hour)
+		require.NoError(t, store.Set(ctx, data))
+		assert.True(t, mr.Exists("session:"+data.ID))
+	})
+
+	t.Run("should use hash tags when asked to", func(t *testing.T) {
+		client, mr := setupRedis(t)
+		store := redisstore.NewStore(client, "session:", redisstore.WithHashTags(true))
+		ctx := context.Background()
+
+		data := session.NewSessionData(time.Hour)
+		require.NoError(t, store.Set(ctx, data))
+		assert.True(t, mr.Exists("session:{"+data.ID+"}"))
+
+		_, err := store.Get(ctx, data.ID)
+		assert.NoError(t, err)
+	})
+}
+
+func TestRedisStore_HashLayout(t *testing.T) {
+	newSession := func() session.SessionData {
+		data := session.NewSessionData(time.Hour)
+		data.Authenticate("user-1")
+		data.Data["cart"] = []any{"apple"}
+		data.Data["theme"] = "dark"
+		return data
+	}
+
+	t.Run("should store sessions as readable hashes", func(t *testing.T) {
+		client, mr := setupRedis(t)
+		store := redisstore.NewStore(client, "session:", redisstore.WithLayout(redisstore.HashLayout))
+		ctx := context.Background()
+
+		data := newSession()
+		require.NoError(t, store.Set(ctx, data))
+
+		key := "session:" + data.ID
+		assert.Equal(t, "user-1", mr.HGet(key, "user_id"))
+		assert.Equal(t, "true", mr.HGet(key, "authenticated"))
+		assert.Equal(t, data.ExpiresAt.Format(time.RFC3339Nano), mr.HGet(key, "expires_at"))
+		assert.Equal(t, `["apple"]`, mr.HGet(key, "data:cart"))
+		assert.Greater(t, mr.TTL(key), time.Duration(0))
+
+		got, err := store.Get(ctx, data.ID)
+		require.NoError(t, err)
+		assert.Equal(t, data.ID, got.ID)
+		assert.Equal(t, data.UserID, got.UserID)
+		assert.True(t, got.Authenticated)
+		assert.True(t, data.CreatedAt.Equal(got.CreatedAt))
+		assert.True(t, got.RotatedAt.IsZero())
+		assert.Equal(t, data.Data, got.Data)
+
+		sessions, err := store.(session.UserIndex).ListByUser(ctx, "user-1")
+		require.NoError(t, err)
+		require.Len(t, sessions, 1)
+		assert.Equal(t, data.ID, sessions[0].ID)
+	})
+
+	t.Run("should drop removed keys on a full write", func(t *testing.T) {
+		client, mr := setupRedis(t)
+		store := redisstore.NewStore(client, "session:", redisstore.WithLayout(redisstore.HashLayout))
+		ctx := context.Background()
+
+		data := newSession()
+		require.NoError(t, store.Set(ctx, data))
+
+		delete(data.Data, "cart")
+		require.NoError(t, store.Set(ctx, data))
+
+		assert.Empty(t, mr.HGet("session:"+data.ID, "data:cart"))
+	})
+
+	t.Run("should read sessions in either layout", func(t *testing.T) {
+		client, mr := setupRedis(t)
+		stringStore := redisstore.NewStore(client, "session:")
+		hashStore := redisstore.NewStore(client, "session:", redisstore.WithLayout(redisstore.HashLayout))
+		ctx := context.Background()
+
+		legacy := newSession()
+		require.NoError(t, stringStore.Set(ctx, legacy))
+		got, err := hashStore.Get(ctx, legacy.ID)
+		require.NoError(t, err)
+		assert.Equal(t, legacy.Data, got.Data)
+
+		require.NoError(t, hashStore.Set(ctx, got))
+		assert.Equal(t, "user-1", mr.HGet("session:"+legacy.ID, "user_id"))
+
+		got, err = stringStore.Get(ctx, legacy.ID)
+		require.NoError(t, err)
+		assert.Equal(t, legacy.Data, got.Data)
+
+		_, err = hashStore.Get(ctx, "missing")
+		assert.ErrorIs(t, err, session.ErrSessionNotFound)
+	})
+
+	t.Run("should read with the command of the configured layout", func(t *testing.T) {
+		client, _ := setupRedis(t)
+		recorder := &commandRecorder{}
+		client.AddHook(recorder)
+		stringStore := redisstore.NewStore(client, "session:")
+		hashStore := redisstore.NewStore(client, "session:", redisstore.WithLayout(redisstore.HashLayout))
+		ctx := context.Background()
+
+		legacy, current := newSession(), newSession()
+		require.NoError(t, stringStore.Set(ctx, legacy))
+		require.NoError(t, hashStore.Set(ctx, current))
+
+		recorder.names = nil
+		_, err := stringStore.Get(ctx, legacy.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"get", "pttl"}, recorder.names)
+
+		recorder.names = nil
+		_, err = hashStore.Get(ctx, current.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"hgetall", "pttl"}, recorder.names)
+
+		recorder.names = nil
+		_, err = hashStore.Get(ctx, legacy.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"hgetall", "pttl", "get", "pttl"}, recorder.names)
+
+		sessions, err := hashStore.(session.UserIndex).ListByUser(ctx, "user-1")
+		require.NoError(t, err)
+		assert.Len(t, sessions, 2)
+	})
+
+	t.Run("should write only the changed keys", func(t *testing.T) {
+		client, mr := setupRedis(t)
+		store := redisstore.NewStore(client, "session:", redisstore.WithLayout(redisstore.HashLayout))
+		partial := store.(session.PartialStore)
+		ctx := context.Background()
+
+		data := newSession()
+		require.NoError(t, store.Set(ctx, data))
+
+		key := "session:" + data.ID
+		mr.HSet(key, "data:other", `"written elsewhere"`)
+
+		data.Data["theme"] = "light"
+		delete(data.Data, "cart")
+		data.ExpiresAt = time.Now().Add(2 * time.Hour)
+		require.NoError(t, partial.Update(ctx, data, session.Changes{
+			Changed: map[string]any{"theme": "light"},
+			Deleted: []string{"cart"},
+		}))
+
+		got, err := store.Get(ctx, data.ID)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"theme": "light", "other": "written elsewhere"}, got.Data)
+		assert.Equal(t, data.ExpiresAt.Format(time.RFC3339Nano), mr.HGet(key, "expires_at"))
+		assert.Greater(t, mr.TTL(key), time.Hour)
+	})
+
+	t.Run("should not update sessions it cannot patch", func(t *testing.T) {
+		client, _ := setupRedis(t)
+		store := redisstore.NewStore(client, "session:", redisstore.WithLayout(redisstore.HashLayout))
+		partial := store.(session.PartialStore)
+		ctx := context.Background()
+
+		err := partial.Update(ctx, newSession(), session.Changes{Added: map[string]any{"a": 1}})
+		assert.ErrorIs(t, err, session.ErrSessionNotFound)
+
+		legacy := newSession()
+		require.NoError(t, redisstore.NewStore(client, "session:").Set(ctx, legacy))
+		err = partial.Update(ctx, legacy, session.Changes{Added: map[string]any{"a": 1}})
+		assert.ErrorIs(t, err, session.ErrPartialUpdateUnsupported)
+
+		err = redisstore.NewStore(client, "session:").(session.PartialStore).Update(ctx, legacy, session.Changes{})
+		assert.ErrorIs(t, err, session.ErrPartialUpdateUnsupported)
+	})
+
+	t.Run("should keep values typed with a binary codec", func(t *testing.T) {
+		client, _ := setupRedis(t)
+		store := redisstore.NewStore(client, "session:",
+			redisstore.WithLayout(redisstore.HashLayout), redisstore.WithCodec(session.GobCodec))
+		ctx := context.Background()
+
+		data := newSession()
+		data.Data["cart"] = []string{"apple"}
+		data.Data["count"] = 3
+		require.NoError(t, store.Set(ctx, data))
+
+		got, err := store.Get(ctx, data.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"apple"}, got.Data["cart"])
+		assert.Equal(t, 3, got.Data["count"])
+	})
+
+	t.Run("should compare and set hashes", func(t *testing.T) {
+		client, _ := setupRedis(t)
+		store := redisstore.NewStore(client, "session:", redisstore.WithLayout(redisstore.HashLayout))
+		versioned := store.(session.VersionedStore)
+		ctx := context.Background()
+
+		data := newSession()
+		require.NoError(t, versioned.CompareAndSet(ctx, data))
+		assert.ErrorIs(t, versioned.CompareAndSet(ctx, data), session.ErrConcurrentModification)
+
+		got, err := store.Get(ctx, data.ID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), got.Version)
+	})
+}
+
+// commandRecorder records the name of every command sent by a client.
+type commandRecorder struct {
+	names []string
+}
+
+func (r *commandRecorder) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (r *commandRecorder) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		r.names = append(r.names, cmd.Name())
+		return next(ctx, cmd)
+	}
+}
+
+func (r *commandRecorder) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		for _, cmd := range cmds {
+			r.names = append(r.names, cmd.Name())
+		}
+		return next(ctx, cmds)
+	}
+}
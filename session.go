@@ -2,24 +2,51 @@ package session
 
 import (
 	"context"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/base64"
+	"maps"
+	"slices"
 	"sync"
 	"time"
 )
 
 const (
 	sessionIDBytes = 24
+
+	// defaultRegenerateGrace is how long the previous ID of a regenerated
+	// session stays valid.
+	defaultRegenerateGrace = 30 * time.Second
+
+	// defaultLockTimeout is how long a request waits for its session lock.
+	defaultLockTimeout = 5 * time.Second
+
+	// defaultConcurrencyRetries is how often a conflicting save is retried.
+	defaultConcurrencyRetries = 3
 )
 
 type Session struct {
 	SessionData
-	modified  bool
+	modified bool
+	touched  bool
+	// seen is set when a touch also refreshed LastSeenAt, which the expiry
+	// alone does not carry.
+	seen      bool
 	oldID     string
 	destroyed bool
 	isNew     bool
-	mu        sync.RWMutex
+	// authChanged is set when Authenticate or Unauthenticate changed who
+	// the session belongs to and the change has not been persisted yet.
+	authChanged bool
+	// changes holds the keys set or deleted since the session was last
+	// persisted, so they can be re-applied on top of a newer copy.
+	changes map[string]change
+	codec   Codec
+	mu      sync.RWMutex
+}
+
+type change struct {
+	value   any
+	deleted bool
+	// existed records whether the key was present before its first change.
+	existed bool
 }
 
 func NewSession(ttl time.Duration) *Session {
@@ -57,17 +84,69 @@ func (s *Session) Get(key string) (any, bool) {
 func (s *Session) Set(key string, value any) *Session {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.modified = true
-	s.SessionData.Set(key, value)
+	s.setLocked(key, value)
 	return s
 }
 
 func (s *Session) Delete(key string) *Session {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.deleteLocked(key)
+	return s
+}
+
+func (s *Session) setLocked(key string, value any) {
+	s.modified = true
+	s.recordChange(key, change{value: value})
+	s.SessionData.Set(key, value)
+}
+
+func (s *Session) deleteLocked(key string) {
 	s.modified = true
+	s.recordChange(key, change{deleted: true})
 	s.SessionData.Delete(key)
-	return s
+}
+
+// recordChange must run before the change is applied to Data.
+func (s *Session) recordChange(key string, c change) {
+	if s.changes == nil {
+		s.changes = make(map[string]change)
+	}
+
+	if prev, ok := s.changes[key]; ok {
+		c.existed = prev.existed
+	} else {
+		_, c.existed = s.Data[key]
+	}
+	s.changes[key] = c
+}
+
+// Changes returns the keys added, changed or deleted since the session was
+// loaded or last persisted.
+func (s *Session) Changes() Changes {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var c Changes
+	for key, ch := range s.changes {
+		switch {
+		case ch.deleted && ch.existed:
+			c.Deleted = append(c.Deleted, key)
+		case ch.deleted:
+		case ch.existed:
+			if c.Changed == nil {
+				c.Changed = make(map[string]any)
+			}
+			c.Changed[key] = ch.value
+		default:
+			if c.Added == nil {
+				c.Added = make(map[string]any)
+			}
+			c.Added[key] = ch.value
+		}
+	}
+	slices.Sort(c.Deleted)
+	return c
 }
 
 func (s *Session) IsModified() bool {
@@ -81,15 +160,32 @@ func (s *Session) MarkClean() *Session {
 	defer s.mu.Unlock()
 
 	s.modified = false
+	s.touched = false
+	s.seen = false
+	s.changes = nil
 	return s
 }
 
+// IsTouched reports whether only the expiry changed since the session was
+// loaded, so a store can extend it without rewriting the data.
+func (s *Session) IsTouched() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.touched && !s.modified
+}
+
 func (s *Session) IsExpired() bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	return s.SessionData.IsExpired()
 }
 
+func (s *Session) IsAbsoluteExpired() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.SessionData.IsAbsoluteExpired()
+}
+
 func (s *Session) Renew(ttl time.Duration) *Session {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -99,6 +195,96 @@ func (s *Session) Renew(ttl time.Duration) *Session {
 	return s
 }
 
+// applyLifetime sets the idle window and absolute deadline on a session
+// that has not been persisted yet.
+func (s *Session) applyLifetime(idleTimeout, absoluteTimeout time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if absoluteTimeout > 0 && s.AbsoluteExpiresAt.IsZero() {
+		s.SessionData.SetAbsoluteTimeout(absoluteTimeout)
+	}
+	if idleTimeout > 0 {
+		if idle := now().Add(idleTimeout); idle.Before(s.ExpiresAt) {
+			s.ExpiresAt = idle
+		}
+	}
+}
+
+// adoptAbsoluteTimeout gives a session stored before an absolute timeout
+// was configured the deadline it would have had from the start, so
+// long-lived sessions are not exempt from the limit.
+func (s *Session) adoptAbsoluteTimeout(timeout time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if timeout <= 0 || !s.AbsoluteExpiresAt.IsZero() {
+		return
+	}
+
+	if s.CreatedAt.IsZero() {
+		s.AbsoluteExpiresAt = now().Add(timeout)
+		s.ExpiresAt = s.capExpiry(s.ExpiresAt)
+	} else {
+		s.SessionData.SetAbsoluteTimeout(timeout)
+	}
+	s.modified = true
+}
+
+// Touch slides the expiry forward like Renew but leaves the session
+// unmodified, so commit can persist it with Toucher instead of a full Set.
+func (s *Session) Touch(ttl time.Duration) *Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expiresAt := s.capExpiry(now().Add(ttl))
+	if expiresAt.Equal(s.ExpiresAt) {
+		return s
+	}
+
+	s.ExpiresAt = expiresAt
+	s.touched = true
+	return s
+}
+
+// recordClient stores the client metadata of the current request. The
+// session is only marked modified when the client changed. A LastSeenAt
+// more than lastSeenInterval old is refreshed as a touch, so steady traffic
+// neither causes a write on every request nor a full one every minute.
+func (s *Session) recordClient(ip, userAgent string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.IP == ip && s.UserAgent == userAgent {
+		if s.modified || at.Sub(s.LastSeenAt) >= lastSeenInterval {
+			s.LastSeenAt = at
+			s.touched = true
+			s.seen = true
+		}
+		return
+	}
+
+	s.IP = ip
+	s.UserAgent = userAgent
+	s.Device = deviceLabel(userAgent)
+	s.LastSeenAt = at
+	s.modified = true
+}
+
+// bind records the fingerprint of the client that created or
+// authenticated the session, replacing any earlier one.
+func (s *Session) bind(fingerprint string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if fingerprint == "" || s.Fingerprint == fingerprint {
+		return
+	}
+
+	s.Fingerprint = fingerprint
+	s.modified = true
+}
+
 func (s *Session) IsAuthenticated() bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -110,6 +296,9 @@ func (s *Session) Authenticate(userID string) *Session {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.modified = true
+	if !s.Authenticated || s.UserID != userID {
+		s.authChanged = true
+	}
 	s.SessionData.Authenticate(userID)
 
 	return s
@@ -119,6 +308,9 @@ func (s *Session) Unauthenticate() *Session {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.modified = true
+	if s.Authenticated {
+		s.authChanged = true
+	}
 	s.SessionData.Unauthenticate()
 	return s
 }
@@ -136,6 +328,8 @@ func (s *Session) Regenerate() *Session {
 	newID := generateId()
 	s.oldID = s.ID
 	s.ID = newID
+	s.RotatedAt = now()
+	s.Version = 0
 	s.modified = true
 	return s
 }
@@ -170,7 +364,14 @@ func (s *Session) IsDestroyed() bool {
 	return s.destroyed
 }
 
+// Flush persists the session before the response is written. Within the
+// middleware it runs the same steps as the end of the request, including
+// the session limit; elsewhere it writes to the store in ctx.
 func (s *Session) Flush(ctx context.Context) error {
+	if h := getHolderContext(ctx); h != nil && h.flush != nil {
+		return h.flush(ctx, s)
+	}
+
 	store, err := GetStore(ctx)
 
 	if err != nil {
@@ -178,22 +379,107 @@ func (s *Session) Flush(ctx context.Context) error {
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if !s.modified {
+	if !s.modified && !s.touched {
 		return nil
 	}
 
-	if err := store.Set(ctx, s.SessionData); err != nil {
+	if t, ok := store.(Toucher); ok && !s.modified {
+		if err := t.Touch(ctx, s.ID, s.ExpiresAt); err != nil {
+			return err
+		}
+	} else if err := store.Set(ctx, s.SessionData); err != nil {
 		return err
 	}
 
 	s.modified = false
+	s.touched = false
+	s.changes = nil
 	return nil
 }
 
+// valueCodec is the codec of the store the session is persisted in, used
+// to convert values that went through it.
+func (s *Session) valueCodec() Codec {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.codec == nil {
+		return JSONCodec
+	}
+	return s.codec
+}
+
 func (s *Session) markPersisted() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.isNew = false
+	s.authChanged = false
+	s.changes = nil
+}
+
+// rebase replaces the session with latest, a newer copy from the store,
+// and re-applies the changes made since the session was loaded.
+func (s *Session) rebase(latest SessionData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data := make(map[string]any, len(latest.Data))
+	maps.Copy(data, latest.Data)
+	for key, c := range s.changes {
+		if c.deleted {
+			delete(data, key)
+		} else {
+			data[key] = c.value
+		}
+	}
+
+	mine := s.SessionData
+	s.SessionData = latest
+	s.Data = data
+	s.UpdatedAt = mine.UpdatedAt
+
+	if s.authChanged {
+		s.Authenticated = mine.Authenticated
+		s.UserID = mine.UserID
+		s.Fingerprint = mine.Fingerprint
+	}
+	if mine.ExpiresAt.After(s.ExpiresAt) {
+		s.ExpiresAt = mine.ExpiresAt
+	}
+	if mine.LastSeenAt.After(s.LastSeenAt) {
+		s.IP = mine.IP
+		s.UserAgent = mine.UserAgent
+		s.Device = mine.Device
+		s.LastSeenAt = mine.LastSeenAt
+	}
+	if s.Fingerprint == "" {
+		s.Fingerprint = mine.Fingerprint
+	}
+}
+
+func (s *Session) setVersion(version int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Version = version
+}
+
+// dueForRotation reports whether the ID is older than interval.
+func (s *Session) dueForRotation(interval time.Duration) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return now().Sub(s.idIssuedAt()) >= interval
+}
+
+// isSeen reports whether a touch has to persist LastSeenAt too.
+func (s *Session) isSeen() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.seen
+}
+
+func (s *Session) isAuthChanged() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.authChanged
 }
 
 func (s *Session) clearOldID() *Session {
@@ -204,8 +490,5 @@ func (s *Session) clearOldID() *Session {
 }
 
 func encodeSessionId(id string, secret string) string {
-	h := hmac.New(sha256.New, []byte(secret))
-	h.Write([]byte(id))
-	sig := base64.RawURLEncoding.EncodeToString(h.Sum(nil))
-	return "s:" + id + "." + sig
+	return "s:" + id + "." + sign(id, []byte(secret))
 }
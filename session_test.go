@@ -1,12 +1,14 @@
 package session
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSession_New(t *testing.T) {
@@ -335,6 +337,91 @@ func TestSession_Renew(t *testing.T) {
 	})
 }
 
+func TestSession_Touch(t *testing.T) {
+	t.Run("should extend expiry without marking modified", func(t *testing.T) {
+		s := NewSession(time.Hour)
+		s.MarkClean()
+		before := s.ExpiresAt
+
+		s.Touch(2 * time.Hour)
+
+		assert.True(t, s.ExpiresAt.After(before))
+		assert.False(t, s.IsModified())
+		assert.True(t, s.IsTouched())
+	})
+
+	t.Run("should not report touched once modified", func(t *testing.T) {
+		s := NewSession(time.Hour)
+		s.MarkClean()
+
+		s.Touch(2 * time.Hour)
+		s.Set("key", "value")
+
+		assert.False(t, s.IsTouched())
+		assert.True(t, s.IsModified())
+	})
+
+	t.Run("should not touch past the absolute deadline", func(t *testing.T) {
+		s := NewSession(time.Hour)
+		s.SetAbsoluteTimeout(time.Hour)
+		s.MarkClean()
+
+		s.Touch(2 * time.Hour)
+
+		assert.False(t, s.IsTouched())
+		assert.Equal(t, s.AbsoluteExpiresAt, s.ExpiresAt)
+	})
+
+	t.Run("should clear touched on MarkClean", func(t *testing.T) {
+		s := NewSession(time.Hour)
+		s.MarkClean()
+		s.Touch(2 * time.Hour)
+
+		s.MarkClean()
+
+		assert.False(t, s.IsTouched())
+	})
+}
+
+func TestSession_Flush(t *testing.T) {
+	t.Run("should set modified sessions", func(t *testing.T) {
+		store := &countingStore{Store: NewMemoryStore()}
+		ctx := withStoreContext(context.Background(), countingToucher{store})
+		s := NewSession(time.Hour)
+
+		require.NoError(t, s.Flush(ctx))
+
+		assert.Equal(t, 1, store.sets)
+		assert.Equal(t, 0, store.touches)
+		assert.False(t, s.IsModified())
+	})
+
+	t.Run("should touch sessions where only the expiry changed", func(t *testing.T) {
+		store := &countingStore{Store: NewMemoryStore()}
+		ctx := withStoreContext(context.Background(), countingToucher{store})
+		s := NewSession(time.Hour)
+		require.NoError(t, s.Flush(ctx))
+
+		s.Touch(2 * time.Hour)
+		require.NoError(t, s.Flush(ctx))
+
+		assert.Equal(t, 1, store.sets)
+		assert.Equal(t, 1, store.touches)
+		assert.False(t, s.IsTouched())
+	})
+
+	t.Run("should skip clean sessions", func(t *testing.T) {
+		store := &countingStore{Store: NewMemoryStore()}
+		ctx := withStoreContext(context.Background(), store)
+		s := NewSession(time.Hour)
+		s.MarkClean()
+
+		require.NoError(t, s.Flush(ctx))
+
+		assert.Equal(t, 0, store.sets)
+	})
+}
+
 func TestSession_IsExpired(t *testing.T) {
 	t.Run("should return true expired", func(t *testing.T) {
 		now = func() time.Time { return time.Now().AddDate(-1, 0, 0) }
@@ -514,3 +601,100 @@ func TestSession_Concurrent(t *testing.T) {
 			"Session should be marked as modified after concurrent writes")
 	})
 }
+
+func TestSession_Rebase(t *testing.T) {
+	t.Run("should re-apply changed keys on top of the latest copy", func(t *testing.T) {
+		base := NewSessionData(time.Hour)
+		base.Data = map[string]any{"kept": 1, "removed": 2, "mine": 0}
+		s := NewSessionFromData(base)
+
+		s.Set("mine", 3)
+		s.Delete("removed")
+
+		latest := base
+		latest.Version = 4
+		latest.Data = map[string]any{"kept": 1, "removed": 2, "mine": 0, "theirs": 5}
+		s.rebase(latest)
+
+		data := s.GetSessionData()
+		assert.Equal(t, map[string]any{"kept": 1, "mine": 3, "theirs": 5}, data.Data)
+		assert.Equal(t, int64(4), data.Version)
+		assert.Contains(t, latest.Data, "removed", "latest copy must not be mutated")
+	})
+
+	t.Run("should keep an authentication change", func(t *testing.T) {
+		base := NewSessionData(time.Hour)
+		s := NewSessionFromData(base)
+		s.Authenticate("user-1")
+
+		s.rebase(base)
+
+		assert.True(t, s.IsAuthenticated())
+		assert.Equal(t, "user-1", s.GetSessionData().UserID)
+	})
+
+	t.Run("should forget changes once persisted", func(t *testing.T) {
+		s := NewSession(time.Hour)
+		s.Set("key", "value")
+		s.markPersisted()
+
+		latest := s.GetSessionData()
+		latest.Data = map[string]any{}
+		s.rebase(latest)
+
+		_, ok := s.Get("key")
+		assert.False(t, ok)
+	})
+}
+
+func TestSession_RegenerateResetsVersion(t *testing.T) {
+	data := NewSessionData(time.Hour)
+	data.Version = 7
+	s := NewSessionFromData(data)
+
+	s.Regenerate()
+
+	assert.Zero(t, s.GetSessionData().Version)
+}
+
+func TestSession_Changes(t *testing.T) {
+	t.Run("should classify changed keys", func(t *testing.T) {
+		data := NewSessionData(time.Hour)
+		data.Data = map[string]any{"changed": 1, "deleted": 2, "kept": 3}
+		s := NewSessionFromData(data)
+
+		s.Set("changed", 10)
+		s.Delete("deleted")
+		s.Set("added", 4)
+		s.Set("temporary", 5)
+		s.Delete("temporary")
+
+		changes := s.Changes()
+		assert.Equal(t, map[string]any{"added": 4}, changes.Added)
+		assert.Equal(t, map[string]any{"changed": 10}, changes.Changed)
+		assert.Equal(t, []string{"deleted"}, changes.Deleted)
+		assert.Equal(t, map[string]any{"added": 4, "changed": 10}, changes.Upserts())
+	})
+
+	t.Run("should treat a deleted and re-set key as changed", func(t *testing.T) {
+		data := NewSessionData(time.Hour)
+		data.Data = map[string]any{"key": 1}
+		s := NewSessionFromData(data)
+
+		s.Delete("key")
+		s.Set("key", 2)
+
+		changes := s.Changes()
+		assert.Equal(t, map[string]any{"key": 2}, changes.Changed)
+		assert.Empty(t, changes.Deleted)
+	})
+
+	t.Run("should be empty when nothing changed", func(t *testing.T) {
+		s := NewSessionFromData(NewSessionData(time.Hour))
+		assert.True(t, s.Changes().IsEmpty())
+
+		s.Set("key", "value")
+		s.MarkClean()
+		assert.True(t, s.Changes().IsEmpty())
+	})
+}
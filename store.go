@@ -2,6 +2,8 @@ package session
 
 import (
 	"context"
+	"sync"
+	"time"
 )
 
 type Store interface {
@@ -9,3 +11,89 @@ type Store interface {
 	Set(ctx context.Context, session SessionData) error
 	Delete(ctx context.Context, id string) error
 }
+
+// CookieStore is implemented by stores that keep the whole session in the
+// cookie value instead of on the server. The Middleware hands the raw cookie
+// value to Decode when loading and writes the result of Encode back.
+type CookieStore interface {
+	Store
+	Encode(ctx context.Context, session SessionData) (string, error)
+	Decode(ctx context.Context, value string) (SessionData, error)
+}
+
+// Toucher is implemented by stores that can move a session's expiry without
+// rewriting its data.
+type Toucher interface {
+	Touch(ctx context.Context, id string, expiresAt time.Time) error
+}
+
+// UserIndex is implemented by stores that can look sessions up by
+// SessionData.UserID. Sessions are listed oldest first.
+type UserIndex interface {
+	ListByUser(ctx context.Context, userID string) ([]SessionData, error)
+	CountByUser(ctx context.Context, userID string) (int, error)
+	DeleteByUser(ctx context.Context, userID string) error
+}
+
+// Locker is implemented by stores that can serialize requests for the same
+// session. Lock blocks until the lock is held or ctx is done and returns
+// the function that releases it.
+type Locker interface {
+	Lock(ctx context.Context, id string) (unlock func() error, err error)
+}
+
+// LockScope holds what a Locker records about the locks taken with one
+// context, see WithLockScope. A request holding its session lock takes a
+// second one for the session limit, so a Locker whose locks each occupy a
+// resource, such as a pooled connection, can look up the one it already
+// holds and take the second lock on it instead of waiting for another.
+type LockScope struct {
+	mu     sync.Mutex
+	values map[any]any
+}
+
+// Load returns the value stored under key.
+func (s *LockScope) Load(key any) (any, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	v, ok := s.values[key]
+	return v, ok
+}
+
+// Store sets the value under key, typically the Locker itself.
+func (s *LockScope) Store(key, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.values == nil {
+		s.values = make(map[any]any)
+	}
+	s.values[key] = value
+}
+
+func (s *LockScope) Delete(key any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.values, key)
+}
+
+// VersionedStore is implemented by stores that support optimistic
+// concurrency. CompareAndSet saves the session only if the stored version
+// equals session.Version, storing it with Version+1, and returns
+// ErrConcurrentModification otherwise. A missing session counts as
+// version zero.
+type VersionedStore interface {
+	CompareAndSet(ctx context.Context, session SessionData) error
+}
+
+// PartialStore is implemented by stores that can apply only the changed
+// keys of a session instead of rewriting its whole Data map. Update writes
+// every other field of session as Set does. It returns ErrSessionNotFound
+// when there is no stored copy to update, and ErrPartialUpdateUnsupported
+// when the stored copy uses a codec or layout it cannot patch, in which
+// case the caller writes the whole session with Set.
+type PartialStore interface {
+	Update(ctx context.Context, session SessionData, changes Changes) error
+}
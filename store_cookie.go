@@ -0,0 +1,97 @@
+package session
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+const (
+	// defaultCookieStoreMaxSize is what the default cookie transport can
+	// carry in chunks.
+	defaultCookieStoreMaxSize = defaultCookieChunkSize * maxCookieChunks
+)
+
+// cookieStore keeps the whole SessionData in the cookie, compressed and
+// sealed with the keyring. Nothing is kept on the server, so Get, Set and
+// Delete are no-ops and a destroyed session only disappears from the
+// client.
+type cookieStore struct {
+	keyring *Keyring
+	maxSize int
+}
+
+func (s *cookieStore) Get(ctx context.Context, id string) (SessionData, error) {
+	return SessionData{}, ErrSessionNotFound
+}
+
+func (s *cookieStore) Set(ctx context.Context, session SessionData) error {
+	return nil
+}
+
+func (s *cookieStore) Delete(ctx context.Context, id string) error {
+	return nil
+}
+
+func (s *cookieStore) Encode(ctx context.Context, session SessionData) (string, error) {
+	data, err := json.Marshal(&session)
+	if err != nil {
+		return "", fmt.Errorf("marshal failed: %w", err)
+	}
+
+	var buf bytes.Buffer
+	zw, _ := flate.NewWriter(&buf, flate.BestCompression)
+	if _, err := zw.Write(data); err != nil {
+		return "", fmt.Errorf("compress failed: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return "", fmt.Errorf("compress failed: %w", err)
+	}
+
+	value := s.keyring.Seal(buf.Bytes())
+	if len(value) > s.maxSize {
+		return "", fmt.Errorf("%w: %d bytes exceeds the %d byte limit", ErrCookieTooLarge, len(value), s.maxSize)
+	}
+
+	return value, nil
+}
+
+func (s *cookieStore) Decode(ctx context.Context, value string) (SessionData, error) {
+	compressed, err := s.keyring.Open(value)
+	if err != nil {
+		return SessionData{}, err
+	}
+
+	data, err := io.ReadAll(flate.NewReader(bytes.NewReader(compressed)))
+	if err != nil {
+		return SessionData{}, fmt.Errorf("%w: decompress failed: %v", ErrInvalidCookie, err)
+	}
+
+	var sess SessionData
+	if err := json.Unmarshal(data, &sess); err != nil {
+		return SessionData{}, fmt.Errorf("%w: unmarshal failed: %v", ErrInvalidCookie, err)
+	}
+
+	return sess, nil
+}
+
+// NewCookieStore creates a client-side store sealed with keyring. Encoded
+// values longer than maxSize bytes are rejected with ErrCookieTooLarge. A
+// maxSize of zero or less allows as much as the cookie transport can split
+// across its chunk cookies with the default chunk size, about 59KB; the
+// transport rejects values that need more chunks at a smaller chunk size.
+// Servers commonly limit request headers to 8KB or more, which bounds the
+// useful size further.
+func NewCookieStore(keyring *Keyring, maxSize int) Store {
+	if maxSize <= 0 {
+		maxSize = defaultCookieStoreMaxSize
+	}
+
+	return &cookieStore{
+		keyring: keyring,
+		maxSize: maxSize,
+	}
+}
@@ -0,0 +1,138 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func TestCookieStore_EncodeDecode(t *testing.T) {
+	keyring := NewKeyring(Key{ID: "k1", Secret: []byte("secret")})
+
+	t.Run("should round trip the whole session", func(t *testing.T) {
+		store := NewCookieStore(keyring, 0).(CookieStore)
+		ctx := context.Background()
+
+		data := NewSessionData(time.Hour)
+		data.Set("role", "admin")
+		data.Authenticate("user-1")
+
+		value, err := store.Encode(ctx, data)
+		require.NoError(t, err)
+		assert.NotContains(t, value, data.ID)
+		assert.NotContains(t, value, "admin")
+
+		decoded, err := store.Decode(ctx, value)
+		require.NoError(t, err)
+		assert.Equal(t, data.ID, decoded.ID)
+		assert.Equal(t, "admin", decoded.Data["role"])
+		assert.True(t, decoded.Authenticated)
+		assert.Equal(t, "user-1", decoded.UserID)
+		assert.True(t, data.ExpiresAt.Equal(decoded.ExpiresAt))
+	})
+
+	t.Run("should reject sessions above the size limit", func(t *testing.T) {
+		store := NewCookieStore(keyring, 4096).(CookieStore)
+
+		data := NewSessionData(time.Hour)
+		data.Set("blob", randomHex(4096))
+
+		_, err := store.Encode(context.Background(), data)
+		assert.ErrorIs(t, err, ErrCookieTooLarge)
+	})
+
+	t.Run("should reject tampered values", func(t *testing.T) {
+		store := NewCookieStore(keyring, 0).(CookieStore)
+
+		_, err := store.Decode(context.Background(), "e:k1:AAAAAAAAAAAAAAAAAAAAAAAAAAAAAA")
+		assert.ErrorIs(t, err, ErrCookieTampered)
+	})
+
+	t.Run("should keep nothing on the server", func(t *testing.T) {
+		store := NewCookieStore(keyring, 0)
+		ctx := context.Background()
+		data := NewSessionData(time.Hour)
+
+		require.NoError(t, store.Set(ctx, data))
+		_, err := store.Get(ctx, data.ID)
+		assert.ErrorIs(t, err, ErrSessionNotFound)
+		assert.NoError(t, store.Delete(ctx, data.ID))
+	})
+}
+
+func TestMiddleware_CookieStore(t *testing.T) {
+	t.Run("should carry the session in the cookie", func(t *testing.T) {
+		store := NewCookieStore(NewKeyring(Key{ID: "k1", Secret: []byte("secret")}), 0)
+
+		var count float64
+		h := newHandler(store, func(w http.ResponseWriter, r *http.Request) {
+			sess := GetOrCreate(r.Context(), time.Hour)
+			v, _ := sess.Get("count")
+			count, _ = v.(float64)
+			sess.Set("count", count+1)
+			w.WriteHeader(http.StatusNoContent)
+		})
+
+		w := serve(t, h)
+		c := responseCookie(w, "sid")
+		require.NotNil(t, c)
+		assert.True(t, strings.HasPrefix(c.Value, "e:k1:"))
+
+		w = serve(t, h, c)
+		assert.Equal(t, float64(1), count)
+
+		serve(t, h, responseCookie(w, "sid"))
+		assert.Equal(t, float64(2), count)
+	})
+
+	t.Run("should report oversized sessions to the error handler", func(t *testing.T) {
+		store := NewCookieStore(NewKeyring(Key{ID: "k1", Secret: []byte("secret")}), 0)
+
+		var handled error
+		h := newHandler(store, func(w http.ResponseWriter, r *http.Request) {
+			GetOrCreate(r.Context(), time.Hour).Set("blob", randomHex(64*1024))
+			w.WriteHeader(http.StatusNoContent)
+		}, WithErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+			handled = err
+			w.WriteHeader(http.StatusInsufficientStorage)
+		}))
+
+		w := serve(t, h)
+		assert.ErrorIs(t, handled, ErrCookieTooLarge)
+		assert.Equal(t, http.StatusInsufficientStorage, w.Code)
+	})
+
+	t.Run("should report sessions that need too many chunk cookies", func(t *testing.T) {
+		store := NewCookieStore(NewKeyring(Key{ID: "k1", Secret: []byte("secret")}), 0)
+
+		var handled error
+		h := newHandler(store, func(w http.ResponseWriter, r *http.Request) {
+			GetOrCreate(r.Context(), time.Hour).Set("blob", randomHex(4096))
+			w.WriteHeader(http.StatusNoContent)
+		},
+			WithCookieChunkSize(100),
+			WithErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+				handled = err
+				w.WriteHeader(http.StatusInsufficientStorage)
+			}),
+		)
+
+		w := serve(t, h)
+		assert.ErrorIs(t, handled, ErrCookieTooLarge)
+		assert.Equal(t, http.StatusInsufficientStorage, w.Code)
+		assert.Empty(t, w.Result().Cookies())
+	})
+}
@@ -1,58 +1,409 @@
 package session
 
 import (
+	"container/list"
 	"context"
+	"encoding/json"
+	"maps"
+	"slices"
 	"sync"
+	"time"
 )
 
-type memoryStore struct {
-	data map[string]SessionData
-	sync.RWMutex
+const (
+	defaultJanitorInterval = time.Minute
+)
+
+// MemoryStore keeps sessions in process memory. A janitor removes expired
+// sessions in the background, and the store can be bounded by entry count
+// or approximate size, evicting the least recently used sessions first.
+// Session values are deep-copied on Set and Get, so, as with an external
+// store, mutating a loaded value never changes what is stored.
+type MemoryStore struct {
+	data map[string]*memoryEntry
+	lru  *list.List
+	mu   sync.RWMutex
+
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
+
+	evictions   uint64
+	expirations uint64
+
+	janitorInterval time.Duration
+	stop            chan struct{}
+	closeOnce       sync.Once
+
+	lockMu sync.Mutex
+	locks  map[string]chan struct{}
+}
+
+type memoryEntry struct {
+	data SessionData
+	size int64
+	elem *list.Element
+}
+
+// MemoryStoreStats is a snapshot of a MemoryStore's size and evictions.
+type MemoryStoreStats struct {
+	Entries int
+	// Bytes is the approximate size of the stored sessions. It is only
+	// tracked when a byte limit is configured.
+	Bytes int64
+	// Evictions counts sessions dropped to stay within the limits.
+	Evictions uint64
+	// Expirations counts expired sessions removed by the janitor.
+	Expirations uint64
+}
+
+type MemoryStoreOption func(*MemoryStore)
+
+// WithMemoryJanitorInterval sets how often expired sessions are removed.
+// Zero disables the janitor. Defaults to one minute.
+func WithMemoryJanitorInterval(interval time.Duration) MemoryStoreOption {
+	return func(s *MemoryStore) {
+		s.janitorInterval = interval
+	}
+}
+
+// WithMemoryMaxEntries caps the number of stored sessions. Zero means no
+// limit.
+func WithMemoryMaxEntries(n int) MemoryStoreOption {
+	return func(s *MemoryStore) {
+		s.maxEntries = n
+	}
+}
+
+// WithMemoryMaxBytes caps the approximate size of the stored sessions,
+// measured as their JSON encoding. Zero means no limit.
+func WithMemoryMaxBytes(n int64) MemoryStoreOption {
+	return func(s *MemoryStore) {
+		s.maxBytes = n
+	}
 }
 
-func (s *memoryStore) Get(ctx context.Context, id string) (SessionData, error) {
-	s.RLock()
-	defer s.RUnlock()
-	data, ok := s.data[id]
+func (s *MemoryStore) Get(ctx context.Context, id string) (SessionData, error) {
+	data, ok := s.load(id)
 	if !ok {
 		return SessionData{}, ErrSessionNotFound
 	}
-	return data, nil
+
+	// Stored Data maps are never mutated in place, so the copy can be made
+	// after the lock is released.
+	return copySession(data), nil
+}
+
+func (s *MemoryStore) load(id string) (SessionData, bool) {
+	if s.bounded() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	} else {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+	}
+
+	entry, ok := s.data[id]
+	if !ok {
+		return SessionData{}, false
+	}
+
+	if s.bounded() {
+		s.lru.MoveToFront(entry.elem)
+	}
+	return entry.data, true
+}
+
+func (s *MemoryStore) Set(ctx context.Context, session SessionData) error {
+	cpy := copySession(session)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.storeLocked(cpy)
+	return nil
+}
+
+func (s *MemoryStore) CompareAndSet(ctx context.Context, session SessionData) error {
+	cpy := copySession(session)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var version int64
+	if entry, ok := s.data[session.ID]; ok {
+		version = entry.data.Version
+	}
+	if version != session.Version {
+		return ErrConcurrentModification
+	}
+
+	cpy.Version++
+	s.storeLocked(cpy)
+	return nil
 }
 
-func (s *memoryStore) Set(ctx context.Context, session SessionData) error {
-	s.Lock()
-	defer s.Unlock()
+func (s *MemoryStore) Update(ctx context.Context, session SessionData, changes Changes) error {
+	upserts := copyData(changes.Upserts())
 
-	dataCopy := make(map[string]any, len(session.Data))
-	for k, v := range session.Data {
-		dataCopy[k] = v
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.data[session.ID]
+	if !ok {
+		return ErrSessionNotFound
 	}
 
-	cpy := SessionData{
-		ID:            session.ID,
-		Data:          dataCopy,
-		CreatedAt:     session.CreatedAt,
-		ExpiresAt:     session.ExpiresAt,
-		UpdatedAt:     session.UpdatedAt,
-		Authenticated: session.Authenticated,
-		UserID:        session.UserID,
+	data := maps.Clone(entry.data.Data)
+	maps.Copy(data, upserts)
+	for _, k := range changes.Deleted {
+		delete(data, k)
 	}
-	s.data[session.ID] = cpy
 
+	session.Data = data
+	s.storeLocked(session)
 	return nil
 }
 
-func (s *memoryStore) Delete(ctx context.Context, id string) error {
-	s.Lock()
-	defer s.Unlock()
+// copySession deep-copies a session's Data, so that neither the caller
+// nor the store sees the other's later mutations of nested values. It runs
+// outside the store lock to keep the critical section short.
+func copySession(session SessionData) SessionData {
+	cpy := session
+	cpy.Data = copyData(session.Data)
+	if cpy.Data == nil {
+		cpy.Data = make(map[string]any)
+	}
+	return cpy
+}
+
+// storeLocked stores cpy, which must not be shared with the caller.
+func (s *MemoryStore) storeLocked(cpy SessionData) {
+	entry, ok := s.data[cpy.ID]
+	if !ok {
+		entry = &memoryEntry{elem: s.lru.PushFront(cpy.ID)}
+		s.data[cpy.ID] = entry
+	} else {
+		s.lru.MoveToFront(entry.elem)
+	}
+
+	s.bytes -= entry.size
+	entry.data = cpy
+	entry.size = s.sizeOf(cpy)
+	s.bytes += entry.size
+
+	s.evictLocked()
+}
 
+// sizeOf approximates the memory held by a session. It is only computed
+// when a byte limit needs it.
+func (s *MemoryStore) sizeOf(session SessionData) int64 {
+	if s.maxBytes <= 0 {
+		return 0
+	}
+
+	encoded, err := json.Marshal(session)
+	if err != nil {
+		return 0
+	}
+	return int64(len(encoded))
+}
+
+func (s *MemoryStore) bounded() bool {
+	return s.maxEntries > 0 || s.maxBytes > 0
+}
+
+// evictLocked drops least recently used sessions until the store is within
+// its limits. The most recent session is always kept.
+func (s *MemoryStore) evictLocked() {
+	for s.lru.Len() > 1 &&
+		((s.maxEntries > 0 && s.lru.Len() > s.maxEntries) || (s.maxBytes > 0 && s.bytes > s.maxBytes)) {
+		s.deleteLocked(s.lru.Back().Value.(string))
+		s.evictions++
+	}
+}
+
+func (s *MemoryStore) deleteLocked(id string) {
+	entry, ok := s.data[id]
+	if !ok {
+		return
+	}
+
+	s.lru.Remove(entry.elem)
+	s.bytes -= entry.size
 	delete(s.data, id)
+}
+
+func (s *MemoryStore) Touch(ctx context.Context, id string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.data[id]
+	if !ok {
+		return ErrSessionNotFound
+	}
+
+	entry.data.ExpiresAt = expiresAt
+	s.lru.MoveToFront(entry.elem)
+	return nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteLocked(id)
 	return nil
 }
 
-func NewMemoryStore() Store {
-	return &memoryStore{
-		data: make(map[string]SessionData),
+func (s *MemoryStore) ListByUser(ctx context.Context, userID string) ([]SessionData, error) {
+	s.mu.RLock()
+	var sessions []SessionData
+	for _, entry := range s.data {
+		if entry.data.UserID == userID && !entry.data.IsExpired() {
+			sessions = append(sessions, entry.data)
+		}
 	}
+	s.mu.RUnlock()
+
+	for i := range sessions {
+		sessions[i] = copySession(sessions[i])
+	}
+
+	slices.SortFunc(sessions, func(a, b SessionData) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	return sessions, nil
+}
+
+func (s *MemoryStore) CountByUser(ctx context.Context, userID string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	for _, entry := range s.data {
+		if entry.data.UserID == userID && !entry.data.IsExpired() {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *MemoryStore) DeleteByUser(ctx context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, entry := range s.data {
+		if entry.data.UserID == userID {
+			s.deleteLocked(id)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Lock(ctx context.Context, id string) (func() error, error) {
+	for {
+		s.lockMu.Lock()
+		held, ok := s.locks[id]
+		if !ok {
+			released := make(chan struct{})
+			s.locks[id] = released
+			s.lockMu.Unlock()
+
+			return func() error {
+				s.lockMu.Lock()
+				delete(s.locks, id)
+				s.lockMu.Unlock()
+				close(released)
+				return nil
+			}, nil
+		}
+		s.lockMu.Unlock()
+
+		select {
+		case <-held:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (s *MemoryStore) Stats() MemoryStoreStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return MemoryStoreStats{
+		Entries:     len(s.data),
+		Bytes:       s.bytes,
+		Evictions:   s.evictions,
+		Expirations: s.expirations,
+	}
+}
+
+// DeleteExpired removes every expired session and returns how many were
+// removed. The janitor calls it on each tick.
+func (s *MemoryStore) DeleteExpired() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := 0
+	for id, entry := range s.data {
+		if entry.data.IsExpired() {
+			s.deleteLocked(id)
+			removed++
+		}
+	}
+	s.expirations += uint64(removed)
+	return removed
+}
+
+// Close stops the janitor. The stored sessions remain readable.
+func (s *MemoryStore) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+	})
+	return nil
+}
+
+func (s *MemoryStore) janitor() {
+	ticker := time.NewTicker(s.janitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.DeleteExpired()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// NewMemoryStore creates an in-memory store. Call Close on the returned
+// *MemoryStore to stop its janitor once the store is no longer used.
+func NewMemoryStore(opts ...MemoryStoreOption) *MemoryStore {
+	s := newMemoryStore(opts...)
+
+	if s.janitorInterval > 0 {
+		go s.janitor()
+	}
+
+	return s
+}
+
+func newMemoryStore(opts ...MemoryStoreOption) *MemoryStore {
+	s := &MemoryStore{
+		data:            make(map[string]*memoryEntry),
+		lru:             list.New(),
+		janitorInterval: defaultJanitorInterval,
+		stop:            make(chan struct{}),
+		locks:           make(map[string]chan struct{}),
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+
+	return s
 }
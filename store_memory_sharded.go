@@ -0,0 +1,183 @@
+package session
+
+import (
+	"context"
+	"hash/maphash"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ShardedMemoryStore spreads sessions over independently locked
+// MemoryStores, picked by a hash of the session ID, so concurrent requests
+// for different sessions rarely wait on each other. Entry and byte limits
+// are split evenly across the shards, so eviction is per shard and only
+// approximately least recently used overall.
+type ShardedMemoryStore struct {
+	shards []*MemoryStore
+	seed   maphash.Seed
+
+	janitorInterval time.Duration
+	stop            chan struct{}
+	closeOnce       sync.Once
+}
+
+// NewShardedMemoryStore creates an in-memory store with the given number of
+// shards. A count below one uses four shards per CPU. Options apply as for
+// NewMemoryStore; a single janitor sweeps all shards. Call Close on the
+// returned *ShardedMemoryStore to stop it.
+func NewShardedMemoryStore(shards int, opts ...MemoryStoreOption) *ShardedMemoryStore {
+	if shards < 1 {
+		shards = 4 * runtime.GOMAXPROCS(0)
+	}
+
+	cfg := newMemoryStore(opts...)
+
+	s := &ShardedMemoryStore{
+		shards:          make([]*MemoryStore, shards),
+		seed:            maphash.MakeSeed(),
+		janitorInterval: cfg.janitorInterval,
+		stop:            make(chan struct{}),
+	}
+
+	for i := range s.shards {
+		s.shards[i] = newMemoryStore(
+			WithMemoryJanitorInterval(0),
+			WithMemoryMaxEntries(perShard(cfg.maxEntries, shards)),
+			WithMemoryMaxBytes(perShard(cfg.maxBytes, int64(shards))),
+		)
+	}
+
+	if s.janitorInterval > 0 {
+		go s.janitor()
+	}
+
+	return s
+}
+
+// perShard splits limit across n shards, rounding up so a non-zero limit
+// never becomes zero (unlimited) on a shard.
+func perShard[T int | int64](limit, n T) T {
+	if limit <= 0 {
+		return 0
+	}
+	return (limit + n - 1) / n
+}
+
+func (s *ShardedMemoryStore) shard(id string) *MemoryStore {
+	return s.shards[maphash.String(s.seed, id)%uint64(len(s.shards))]
+}
+
+func (s *ShardedMemoryStore) Get(ctx context.Context, id string) (SessionData, error) {
+	return s.shard(id).Get(ctx, id)
+}
+
+func (s *ShardedMemoryStore) Set(ctx context.Context, session SessionData) error {
+	return s.shard(session.ID).Set(ctx, session)
+}
+
+func (s *ShardedMemoryStore) CompareAndSet(ctx context.Context, session SessionData) error {
+	return s.shard(session.ID).CompareAndSet(ctx, session)
+}
+
+func (s *ShardedMemoryStore) Update(ctx context.Context, session SessionData, changes Changes) error {
+	return s.shard(session.ID).Update(ctx, session, changes)
+}
+
+func (s *ShardedMemoryStore) Touch(ctx context.Context, id string, expiresAt time.Time) error {
+	return s.shard(id).Touch(ctx, id, expiresAt)
+}
+
+func (s *ShardedMemoryStore) Delete(ctx context.Context, id string) error {
+	return s.shard(id).Delete(ctx, id)
+}
+
+func (s *ShardedMemoryStore) Lock(ctx context.Context, id string) (func() error, error) {
+	return s.shard(id).Lock(ctx, id)
+}
+
+// ListByUser has to visit every shard, since sessions are placed by ID.
+func (s *ShardedMemoryStore) ListByUser(ctx context.Context, userID string) ([]SessionData, error) {
+	var sessions []SessionData
+	for _, shard := range s.shards {
+		found, err := shard.ListByUser(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, found...)
+	}
+
+	slices.SortFunc(sessions, func(a, b SessionData) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	return sessions, nil
+}
+
+func (s *ShardedMemoryStore) CountByUser(ctx context.Context, userID string) (int, error) {
+	count := 0
+	for _, shard := range s.shards {
+		n, err := shard.CountByUser(ctx, userID)
+		if err != nil {
+			return 0, err
+		}
+		count += n
+	}
+	return count, nil
+}
+
+func (s *ShardedMemoryStore) DeleteByUser(ctx context.Context, userID string) error {
+	for _, shard := range s.shards {
+		if err := shard.DeleteByUser(ctx, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stats sums the stats of all shards. Shards are read one after another,
+// so the result is not an atomic snapshot.
+func (s *ShardedMemoryStore) Stats() MemoryStoreStats {
+	var stats MemoryStoreStats
+	for _, shard := range s.shards {
+		st := shard.Stats()
+		stats.Entries += st.Entries
+		stats.Bytes += st.Bytes
+		stats.Evictions += st.Evictions
+		stats.Expirations += st.Expirations
+	}
+	return stats
+}
+
+// DeleteExpired removes every expired session and returns how many were
+// removed. Shards are swept one at a time.
+func (s *ShardedMemoryStore) DeleteExpired() int {
+	removed := 0
+	for _, shard := range s.shards {
+		removed += shard.DeleteExpired()
+	}
+	return removed
+}
+
+// Close stops the janitor. The stored sessions remain readable.
+func (s *ShardedMemoryStore) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+	})
+	return nil
+}
+
+func (s *ShardedMemoryStore) janitor() {
+	ticker := time.NewTicker(s.janitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.DeleteExpired()
+		case <-s.stop:
+			return
+		}
+	}
+}
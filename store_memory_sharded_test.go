@@ -0,0 +1,159 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardedMemoryStore(t *testing.T) {
+	t.Run("should store sessions across shards", func(t *testing.T) {
+		store := NewShardedMemoryStore(8, WithMemoryJanitorInterval(0))
+		ctx := context.Background()
+
+		ids := make([]string, 100)
+		for i := range ids {
+			ids[i] = storedSession(t, store, time.Hour).ID
+		}
+
+		for _, id := range ids {
+			got, err := store.Get(ctx, id)
+			require.NoError(t, err)
+			assert.Equal(t, id, got.ID)
+		}
+
+		used := 0
+		for _, shard := range store.shards {
+			if shard.Stats().Entries > 0 {
+				used++
+			}
+		}
+		assert.Greater(t, used, 1)
+		assert.Equal(t, len(ids), store.Stats().Entries)
+
+		require.NoError(t, store.Delete(ctx, ids[0]))
+		_, err := store.Get(ctx, ids[0])
+		assert.ErrorIs(t, err, ErrSessionNotFound)
+	})
+
+	t.Run("should create copy of data to prevent mutation", func(t *testing.T) {
+		store := NewShardedMemoryStore(4, WithMemoryJanitorInterval(0))
+		ctx := context.Background()
+
+		data := NewSessionData(time.Hour)
+		data.Data["key"] = "original"
+		require.NoError(t, store.Set(ctx, data))
+
+		data.Data["key"] = "modified"
+
+		retrieved, err := store.Get(ctx, data.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "original", retrieved.Data["key"])
+	})
+
+	t.Run("should list sessions of a user from every shard", func(t *testing.T) {
+		store := NewShardedMemoryStore(8, WithMemoryJanitorInterval(0))
+		var index UserIndex = store
+		ctx := context.Background()
+
+		base := time.Now()
+		for i := range 20 {
+			data := NewSessionData(time.Hour)
+			data.UserID = "user-1"
+			data.CreatedAt = base.Add(time.Duration(20-i) * time.Minute)
+			require.NoError(t, store.Set(ctx, data))
+		}
+
+		sessions, err := index.ListByUser(ctx, "user-1")
+		require.NoError(t, err)
+		require.Len(t, sessions, 20)
+		for i := 1; i < len(sessions); i++ {
+			assert.True(t, sessions[i-1].CreatedAt.Before(sessions[i].CreatedAt))
+		}
+
+		require.NoError(t, index.DeleteByUser(ctx, "user-1"))
+		count, err := index.CountByUser(ctx, "user-1")
+		require.NoError(t, err)
+		assert.Zero(t, count)
+	})
+
+	t.Run("should split limits across shards", func(t *testing.T) {
+		store := NewShardedMemoryStore(4, WithMemoryMaxEntries(10), WithMemoryJanitorInterval(0))
+
+		for range 100 {
+			storedSession(t, store, time.Hour)
+		}
+
+		stats := store.Stats()
+		assert.LessOrEqual(t, stats.Entries, 12)
+		assert.Equal(t, uint64(100-stats.Entries), stats.Evictions)
+	})
+
+	t.Run("should remove expired sessions in the background", func(t *testing.T) {
+		store := NewShardedMemoryStore(4, WithMemoryJanitorInterval(10*time.Millisecond))
+		defer store.Close()
+
+		expired := NewSessionData(time.Hour)
+		expired.ExpiresAt = time.Now().Add(-time.Minute)
+		require.NoError(t, store.Set(context.Background(), expired))
+		storedSession(t, store, time.Hour)
+
+		assert.Eventually(t, func() bool {
+			return store.Stats().Expirations == 1
+		}, time.Second, 10*time.Millisecond)
+		assert.Equal(t, 1, store.Stats().Entries)
+	})
+
+	t.Run("should default the shard count", func(t *testing.T) {
+		store := NewShardedMemoryStore(0, WithMemoryJanitorInterval(0))
+
+		assert.NotEmpty(t, store.shards)
+	})
+}
+
+// benchmarkStore runs a read-heavy mix of Get and Set from parallel
+// goroutines over a fixed set of sessions.
+func benchmarkStore(b *testing.B, store Store) {
+	ctx := context.Background()
+
+	ids := make([]string, 10000)
+	for i := range ids {
+		data := NewSessionData(time.Hour)
+		data.Data["user_id"] = fmt.Sprintf("user-%d", i)
+		data.Data["cart"] = []string{"a", "b", "c"}
+		require.NoError(b, store.Set(ctx, data))
+		ids[i] = data.ID
+	}
+
+	var seq atomic.Uint64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		n := seq.Add(1) * 7919
+		for pb.Next() {
+			n++
+			id := ids[n%uint64(len(ids))]
+			data, err := store.Get(ctx, id)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if n%4 == 0 {
+				if err := store.Set(ctx, data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+func BenchmarkMemoryStore(b *testing.B) {
+	benchmarkStore(b, NewMemoryStore(WithMemoryJanitorInterval(0)))
+}
+
+func BenchmarkShardedMemoryStore(b *testing.B) {
+	benchmarkStore(b, NewShardedMemoryStore(0, WithMemoryJanitorInterval(0)))
+}
@@ -2,6 +2,7 @@ package session
 
 import (
 	"context"
+	"strings"
 	"testing"
 	"time"
 
@@ -102,9 +103,51 @@ func TestMemoryStore_Set(t *testing.T) {
 		_, exists := retrieved.Data["another_key"]
 		assert.False(t, exists, "New key should not appear in stored data")
 	})
+
+	t.Run("should copy nested values", func(t *testing.T) {
+		store := NewMemoryStore()
+		ctx := context.Background()
+
+		cart := []string{"apple"}
+		prefs := map[string]any{"theme": "dark"}
+		data := NewSessionData(time.Hour)
+		data.Data["cart"] = cart
+		data.Data["prefs"] = prefs
+		require.NoError(t, store.Set(ctx, data))
+
+		cart[0] = "pear"
+		prefs["theme"] = "light"
+
+		retrieved, err := store.Get(ctx, data.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"apple"}, retrieved.Data["cart"])
+		assert.Equal(t, map[string]any{"theme": "dark"}, retrieved.Data["prefs"])
+	})
 }
 
 func TestMemoryStore_Get(t *testing.T) {
+	t.Run("should not share stored values with callers", func(t *testing.T) {
+		store := NewMemoryStore()
+		ctx := context.Background()
+
+		data := NewSessionData(time.Hour)
+		data.Data["cart"] = []string{"apple"}
+		data.Data["prefs"] = map[string]any{"theme": "dark"}
+		require.NoError(t, store.Set(ctx, data))
+
+		first, err := store.Get(ctx, data.ID)
+		require.NoError(t, err)
+		first.Data["cart"].([]string)[0] = "pear"
+		first.Data["prefs"].(map[string]any)["theme"] = "light"
+		first.Data["added"] = true
+
+		second, err := store.Get(ctx, data.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"apple"}, second.Data["cart"])
+		assert.Equal(t, map[string]any{"theme": "dark"}, second.Data["prefs"])
+		assert.NotContains(t, second.Data, "added")
+	})
+
 	t.Run("should retrieve existing session", func(t *testing.T) {
 		store := NewMemoryStore()
 		ctx := context.Background()
@@ -209,3 +252,311 @@ func TestMemoryStore_Delete(t *testing.T) {
 		assert.NoError(t, err)
 	})
 }
+
+func TestMemoryStore_Touch(t *testing.T) {
+	t.Run("should update only the expiry", func(t *testing.T) {
+		store := NewMemoryStore()
+		ctx := context.Background()
+
+		data := SessionData{
+			ID:        "session-touch",
+			Data:      map[string]any{"key": "value"},
+			CreatedAt: time.Now(),
+			ExpiresAt: time.Now().Add(1 * time.Hour),
+			UpdatedAt: time.Now(),
+		}
+		require.NoError(t, store.Set(ctx, data))
+
+		expiresAt := time.Now().Add(2 * time.Hour)
+		err := store.Touch(ctx, "session-touch", expiresAt)
+		require.NoError(t, err)
+
+		retrieved, err := store.Get(ctx, "session-touch")
+		require.NoError(t, err)
+		assert.Equal(t, expiresAt, retrieved.ExpiresAt)
+		assert.Equal(t, "value", retrieved.Data["key"])
+	})
+
+	t.Run("should return error for non-existent session", func(t *testing.T) {
+		store := NewMemoryStore()
+
+		err := store.Touch(context.Background(), "missing", time.Now())
+		assert.ErrorIs(t, err, ErrSessionNotFound)
+	})
+}
+
+func TestMemoryStore_UserIndex(t *testing.T) {
+	newSession := func(id, userID string, createdAt time.Time) SessionData {
+		return SessionData{
+			ID:        id,
+			UserID:    userID,
+			Data:      make(map[string]any),
+			CreatedAt: createdAt,
+			ExpiresAt: time.Now().Add(1 * time.Hour),
+			UpdatedAt: createdAt,
+		}
+	}
+
+	t.Run("should list sessions of a user oldest first", func(t *testing.T) {
+		store := NewMemoryStore()
+		var index UserIndex = store
+		ctx := context.Background()
+
+		base := time.Now()
+		require.NoError(t, store.Set(ctx, newSession("b", "user-1", base.Add(time.Minute))))
+		require.NoError(t, store.Set(ctx, newSession("a", "user-1", base)))
+		require.NoError(t, store.Set(ctx, newSession("c", "user-2", base)))
+
+		expired := newSession("d", "user-1", base)
+		expired.ExpiresAt = time.Now().Add(-time.Minute)
+		require.NoError(t, store.Set(ctx, expired))
+
+		sessions, err := index.ListByUser(ctx, "user-1")
+		require.NoError(t, err)
+		require.Len(t, sessions, 2)
+		assert.Equal(t, "a", sessions[0].ID)
+		assert.Equal(t, "b", sessions[1].ID)
+
+		count, err := index.CountByUser(ctx, "user-1")
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("should delete all sessions of a user", func(t *testing.T) {
+		store := NewMemoryStore()
+		var index UserIndex = store
+		ctx := context.Background()
+
+		require.NoError(t, store.Set(ctx, newSession("a", "user-1", time.Now())))
+		require.NoError(t, store.Set(ctx, newSession("b", "user-1", time.Now())))
+		require.NoError(t, store.Set(ctx, newSession("c", "user-2", time.Now())))
+
+		require.NoError(t, index.DeleteByUser(ctx, "user-1"))
+
+		count, err := index.CountByUser(ctx, "user-1")
+		require.NoError(t, err)
+		assert.Zero(t, count)
+
+		_, err = store.Get(ctx, "c")
+		assert.NoError(t, err)
+	})
+}
+
+func TestMemoryStore_Lock(t *testing.T) {
+	t.Run("should block until the lock is released", func(t *testing.T) {
+		var locker Locker = NewMemoryStore()
+		ctx := context.Background()
+
+		unlock, err := locker.Lock(ctx, "session-123")
+		require.NoError(t, err)
+
+		acquired := make(chan struct{})
+		go func() {
+			unlock, err := locker.Lock(ctx, "session-123")
+			if err == nil {
+				_ = unlock()
+			}
+			close(acquired)
+		}()
+
+		select {
+		case <-acquired:
+			t.Fatal("lock acquired while held")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		require.NoError(t, unlock())
+
+		select {
+		case <-acquired:
+		case <-time.After(time.Second):
+			t.Fatal("lock not acquired after release")
+		}
+	})
+
+	t.Run("should give up when the context is done", func(t *testing.T) {
+		var locker Locker = NewMemoryStore()
+
+		_, err := locker.Lock(context.Background(), "session-123")
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		_, err = locker.Lock(ctx, "session-123")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("should lock sessions independently", func(t *testing.T) {
+		var locker Locker = NewMemoryStore()
+
+		_, err := locker.Lock(context.Background(), "session-1")
+		require.NoError(t, err)
+
+		unlock, err := locker.Lock(context.Background(), "session-2")
+		require.NoError(t, err)
+		assert.NoError(t, unlock())
+	})
+}
+
+func TestMemoryStore_CompareAndSet(t *testing.T) {
+	t.Run("should increment the version", func(t *testing.T) {
+		store := NewMemoryStore()
+		var versioned VersionedStore = store
+		ctx := context.Background()
+
+		data := NewSessionData(time.Hour)
+		require.NoError(t, versioned.CompareAndSet(ctx, data))
+
+		stored, err := store.Get(ctx, data.ID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), stored.Version)
+
+		require.NoError(t, versioned.CompareAndSet(ctx, stored))
+
+		stored, err = store.Get(ctx, data.ID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), stored.Version)
+	})
+
+	t.Run("should reject stale versions", func(t *testing.T) {
+		store := NewMemoryStore()
+		var versioned VersionedStore = store
+		ctx := context.Background()
+
+		data := NewSessionData(time.Hour)
+		require.NoError(t, versioned.CompareAndSet(ctx, data))
+
+		err := versioned.CompareAndSet(ctx, data)
+		assert.ErrorIs(t, err, ErrConcurrentModification)
+	})
+
+	t.Run("should reject versioned writes of missing sessions", func(t *testing.T) {
+		data := NewSessionData(time.Hour)
+		data.Version = 3
+
+		err := NewMemoryStore().CompareAndSet(context.Background(), data)
+		assert.ErrorIs(t, err, ErrConcurrentModification)
+	})
+}
+
+func TestMemoryStore_Update(t *testing.T) {
+	t.Run("should apply only the changed keys", func(t *testing.T) {
+		store := NewMemoryStore()
+		ctx := context.Background()
+
+		data := NewSessionData(time.Hour)
+		data.Data = map[string]any{"kept": 1, "changed": 2, "deleted": 3}
+		require.NoError(t, store.Set(ctx, data))
+
+		stale := data
+		stale.Data = map[string]any{}
+		stale.Authenticate("user-1")
+		changes := Changes{
+			Added:   map[string]any{"added": 4},
+			Changed: map[string]any{"changed": 20},
+			Deleted: []string{"deleted"},
+		}
+		require.NoError(t, store.Update(ctx, stale, changes))
+
+		stored, err := store.Get(ctx, data.ID)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"kept": 1, "changed": 20, "added": 4}, stored.Data)
+		assert.Equal(t, "user-1", stored.UserID)
+	})
+
+	t.Run("should return error for non-existent session", func(t *testing.T) {
+		err := NewMemoryStore().Update(context.Background(), NewSessionData(time.Hour), Changes{})
+		assert.ErrorIs(t, err, ErrSessionNotFound)
+	})
+}
+
+func TestMemoryStore_Janitor(t *testing.T) {
+	t.Run("should remove expired sessions in the background", func(t *testing.T) {
+		store := NewMemoryStore(WithMemoryJanitorInterval(10 * time.Millisecond))
+		defer store.Close()
+		ctx := context.Background()
+
+		expired := NewSessionData(time.Hour)
+		expired.ExpiresAt = time.Now().Add(-time.Minute)
+		require.NoError(t, store.Set(ctx, expired))
+		live := storedSession(t, store, time.Hour)
+
+		assert.Eventually(t, func() bool {
+			return store.Stats().Expirations == 1
+		}, time.Second, 10*time.Millisecond)
+
+		_, err := store.Get(ctx, expired.ID)
+		assert.ErrorIs(t, err, ErrSessionNotFound)
+		_, err = store.Get(ctx, live.ID)
+		assert.NoError(t, err)
+	})
+
+	t.Run("should stop on close", func(t *testing.T) {
+		store := NewMemoryStore(WithMemoryJanitorInterval(10 * time.Millisecond))
+		require.NoError(t, store.Close())
+		require.NoError(t, store.Close())
+
+		expired := NewSessionData(time.Hour)
+		expired.ExpiresAt = time.Now().Add(-time.Minute)
+		require.NoError(t, store.Set(context.Background(), expired))
+
+		time.Sleep(30 * time.Millisecond)
+		assert.Equal(t, 1, store.Stats().Entries)
+	})
+}
+
+func TestMemoryStore_Limits(t *testing.T) {
+	t.Run("should evict the least recently used session", func(t *testing.T) {
+		store := NewMemoryStore(WithMemoryMaxEntries(2), WithMemoryJanitorInterval(0))
+		ctx := context.Background()
+
+		first := storedSession(t, store, time.Hour)
+		second := storedSession(t, store, time.Hour)
+
+		_, err := store.Get(ctx, first.ID)
+		require.NoError(t, err)
+
+		third := storedSession(t, store, time.Hour)
+
+		_, err = store.Get(ctx, second.ID)
+		assert.ErrorIs(t, err, ErrSessionNotFound)
+		_, err = store.Get(ctx, first.ID)
+		assert.NoError(t, err)
+		_, err = store.Get(ctx, third.ID)
+		assert.NoError(t, err)
+
+		stats := store.Stats()
+		assert.Equal(t, 2, stats.Entries)
+		assert.Equal(t, uint64(1), stats.Evictions)
+	})
+
+	t.Run("should stay within the byte limit", func(t *testing.T) {
+		store := NewMemoryStore(WithMemoryMaxBytes(2048), WithMemoryJanitorInterval(0))
+		ctx := context.Background()
+
+		for range 20 {
+			data := NewSessionData(time.Hour)
+			data.Data["payload"] = strings.Repeat("x", 200)
+			require.NoError(t, store.Set(ctx, data))
+		}
+
+		stats := store.Stats()
+		assert.LessOrEqual(t, stats.Bytes, int64(2048))
+		assert.Positive(t, stats.Evictions)
+		assert.Equal(t, 20, stats.Entries+int(stats.Evictions))
+	})
+
+	t.Run("should track size across updates and deletes", func(t *testing.T) {
+		store := NewMemoryStore(WithMemoryMaxBytes(1<<20), WithMemoryJanitorInterval(0))
+		ctx := context.Background()
+
+		data := storedSession(t, store, time.Hour)
+		data.Data["payload"] = strings.Repeat("x", 500)
+		require.NoError(t, store.Set(ctx, data))
+		assert.Greater(t, store.Stats().Bytes, int64(500))
+
+		require.NoError(t, store.Delete(ctx, data.ID))
+		assert.Zero(t, store.Stats().Bytes)
+	})
+}
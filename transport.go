@@ -0,0 +1,157 @@
+package session
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Transport carries the encoded session value between client and server.
+// Read returns ErrNoCookie or ErrNoToken when the request carries no
+// session value at all. Write returns an error, such as ErrCookieTooLarge,
+// when the value cannot be handed back to the client.
+type Transport interface {
+	Read(r *http.Request) (string, error)
+	Write(w http.ResponseWriter, r *http.Request, value string, expiresAt time.Time) error
+	Clear(w http.ResponseWriter, r *http.Request)
+}
+
+type headerTransport struct {
+	header         string
+	scheme         string
+	responseHeader string
+}
+
+// NewHeaderTransport reads the session value from the named request header
+// and writes it back in the response header of the same name.
+func NewHeaderTransport(header string) Transport {
+	return &headerTransport{
+		header:         header,
+		responseHeader: header,
+	}
+}
+
+// NewBearerTransport reads the session value from an
+// "Authorization: Bearer <value>" header and writes it back in
+// responseHeader, for example "X-Session-Token".
+func NewBearerTransport(responseHeader string) Transport {
+	return &headerTransport{
+		header:         "Authorization",
+		scheme:         "Bearer",
+		responseHeader: responseHeader,
+	}
+}
+
+func (t *headerTransport) Read(r *http.Request) (string, error) {
+	value := r.Header.Get(t.header)
+	if value == "" {
+		return "", ErrNoToken
+	}
+
+	if t.scheme != "" {
+		scheme, token, ok := strings.Cut(value, " ")
+		if !ok || !strings.EqualFold(scheme, t.scheme) {
+			return "", ErrNoToken
+		}
+		value = strings.TrimSpace(token)
+	}
+
+	if value == "" {
+		return "", ErrInvalidCookie
+	}
+
+	return value, nil
+}
+
+func (t *headerTransport) Write(w http.ResponseWriter, r *http.Request, value string, expiresAt time.Time) error {
+	w.Header().Set(t.responseHeader, value)
+	return nil
+}
+
+func (t *headerTransport) Clear(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(t.responseHeader, "")
+}
+
+type queryTransport struct {
+	param string
+}
+
+// NewQueryTransport reads the session value from a URL query parameter. A
+// URL cannot be rewritten from a response, so Write and Clear do nothing;
+// combine it with another transport in a chain to hand the value back.
+func NewQueryTransport(param string) Transport {
+	return &queryTransport{param: param}
+}
+
+func (t *queryTransport) Read(r *http.Request) (string, error) {
+	value := r.URL.Query().Get(t.param)
+	if value == "" {
+		return "", ErrNoToken
+	}
+	return value, nil
+}
+
+func (t *queryTransport) Write(w http.ResponseWriter, r *http.Request, value string, expiresAt time.Time) error {
+	return nil
+}
+
+func (t *queryTransport) Clear(w http.ResponseWriter, r *http.Request) {
+}
+
+type transportChain struct {
+	transports []Transport
+}
+
+// NewTransportChain tries each transport in order and uses the first one
+// that finds a session value. Responses are written through the transport
+// the request arrived on, or through the first transport for requests that
+// carried no value.
+func NewTransportChain(transports ...Transport) Transport {
+	if len(transports) == 0 {
+		panic("session: transport chain needs at least one transport")
+	}
+
+	return &transportChain{transports: transports}
+}
+
+func (c *transportChain) Read(r *http.Request) (string, error) {
+	var firstErr error
+	for _, t := range c.transports {
+		value, err := t.Read(r)
+		if err == nil {
+			return value, nil
+		}
+		if firstErr == nil && !isNoValue(err) {
+			firstErr = err
+		}
+	}
+
+	if firstErr != nil {
+		return "", firstErr
+	}
+
+	return "", ErrNoToken
+}
+
+func (c *transportChain) Write(w http.ResponseWriter, r *http.Request, value string, expiresAt time.Time) error {
+	return c.match(r).Write(w, r, value, expiresAt)
+}
+
+func (c *transportChain) Clear(w http.ResponseWriter, r *http.Request) {
+	c.match(r).Clear(w, r)
+}
+
+func (c *transportChain) match(r *http.Request) Transport {
+	for _, t := range c.transports {
+		if _, err := t.Read(r); !isNoValue(err) {
+			return t
+		}
+	}
+
+	return c.transports[0]
+}
+
+func isNoValue(err error) bool {
+	return errors.Is(err, ErrNoCookie) || errors.Is(err, ErrNoToken)
+}
@@ -0,0 +1,143 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeaderTransport(t *testing.T) {
+	t.Run("should read and write the same header", func(t *testing.T) {
+		tr := NewHeaderTransport("X-Session-Token")
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+		_, err := tr.Read(r)
+		assert.ErrorIs(t, err, ErrNoToken)
+
+		r.Header.Set("X-Session-Token", "value")
+		value, err := tr.Read(r)
+		require.NoError(t, err)
+		assert.Equal(t, "value", value)
+
+		w := httptest.NewRecorder()
+		tr.Write(w, r, "next", time.Now())
+		assert.Equal(t, "next", w.Header().Get("X-Session-Token"))
+	})
+}
+
+func TestBearerTransport(t *testing.T) {
+	t.Run("should read bearer tokens", func(t *testing.T) {
+		tr := NewBearerTransport("X-Session-Token")
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+		r.Header.Set("Authorization", "bearer value")
+		value, err := tr.Read(r)
+		require.NoError(t, err)
+		assert.Equal(t, "value", value)
+
+		w := httptest.NewRecorder()
+		tr.Write(w, r, "next", time.Now())
+		assert.Equal(t, "next", w.Header().Get("X-Session-Token"))
+	})
+
+	t.Run("should ignore other authorization schemes", func(t *testing.T) {
+		tr := NewBearerTransport("X-Session-Token")
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Basic dXNlcjpwYXNz")
+
+		_, err := tr.Read(r)
+		assert.ErrorIs(t, err, ErrNoToken)
+	})
+}
+
+func TestQueryTransport(t *testing.T) {
+	t.Run("should read the query parameter", func(t *testing.T) {
+		tr := NewQueryTransport("session")
+
+		value, err := tr.Read(httptest.NewRequest(http.MethodGet, "/?session=value", nil))
+		require.NoError(t, err)
+		assert.Equal(t, "value", value)
+
+		_, err = tr.Read(httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.ErrorIs(t, err, ErrNoToken)
+	})
+}
+
+func TestTransportChain(t *testing.T) {
+	newChain := func() Transport {
+		return NewTransportChain(
+			NewCookieTransport(CookieConfig{Name: "sid", Path: "/", MaxAge: time.Hour}),
+			NewBearerTransport("X-Session-Token"),
+		)
+	}
+
+	t.Run("should read from the first transport with a value", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Bearer token")
+
+		value, err := newChain().Read(r)
+		require.NoError(t, err)
+		assert.Equal(t, "token", value)
+
+		r.AddCookie(&http.Cookie{Name: "sid", Value: "cookie"})
+		value, err = newChain().Read(r)
+		require.NoError(t, err)
+		assert.Equal(t, "cookie", value)
+	})
+
+	t.Run("should return ErrNoToken when nothing matches", func(t *testing.T) {
+		_, err := newChain().Read(httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.ErrorIs(t, err, ErrNoToken)
+	})
+
+	t.Run("should write through the transport the request used", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Bearer token")
+		w := httptest.NewRecorder()
+
+		newChain().Write(w, r, "next", time.Now().Add(time.Hour))
+
+		assert.Equal(t, "next", w.Header().Get("X-Session-Token"))
+		assert.Empty(t, w.Result().Cookies())
+	})
+
+	t.Run("should write through the first transport for new sessions", func(t *testing.T) {
+		w := httptest.NewRecorder()
+
+		newChain().Write(w, httptest.NewRequest(http.MethodGet, "/", nil), "next", time.Now().Add(time.Hour))
+
+		assert.Empty(t, w.Header().Get("X-Session-Token"))
+		require.Len(t, w.Result().Cookies(), 1)
+	})
+}
+
+func TestMiddleware_Transport(t *testing.T) {
+	t.Run("should load and write back sessions over a bearer header", func(t *testing.T) {
+		store := NewMemoryStore()
+		keyring := NewKeyring(Key{Secret: []byte("secret")})
+		data := storedSession(t, store, time.Hour)
+
+		var loaded *Session
+		h := newHandler(store, capture(&loaded),
+			WithKeyring(keyring),
+			WithTransport(NewTransportChain(
+				NewCookieTransport(CookieConfig{Name: "sid", Path: "/", MaxAge: time.Hour}),
+				NewBearerTransport("X-Session-Token"),
+			)),
+		)
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Bearer "+keyring.Sign(data.ID))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		require.NotNil(t, loaded)
+		assert.Equal(t, data.ID, loaded.ID)
+		assert.Equal(t, keyring.Sign(data.ID), w.Header().Get("X-Session-Token"))
+		assert.Empty(t, w.Result().Cookies())
+	})
+}
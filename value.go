@@ -0,0 +1,46 @@
+package session
+
+import (
+	"fmt"
+)
+
+// GetAs returns the value stored under key as a T, whichever store the
+// session was loaded from. It returns ErrKeyNotFound for missing keys and
+// ErrInvalidValue when the value cannot be represented as a T.
+func GetAs[T any](s *Session, key string) (T, error) {
+	raw, ok := s.Get(key)
+	if !ok {
+		var zero T
+		return zero, ErrKeyNotFound
+	}
+
+	return convertValue[T](s.valueCodec(), raw)
+}
+
+// SetAs stores value under key. It is the typed counterpart of GetAs and
+// registers T with gob, so the value survives a GobCodec store as a T.
+func SetAs[T any](s *Session, key string, value T) *Session {
+	registerGob(value)
+	return s.Set(key, value)
+}
+
+// convertValue returns raw as a T. Values that went through a store come
+// back in the generic shape of its codec (float64 for JSON numbers,
+// map[string]any for structs), so anything that is not already a T is
+// re-encoded and decoded into one with the same codec.
+func convertValue[T any](codec Codec, raw any) (T, error) {
+	if v, ok := raw.(T); ok {
+		return v, nil
+	}
+
+	var v T
+	data, err := codec.Marshal(raw)
+	if err != nil {
+		return v, fmt.Errorf("%w: %v", ErrInvalidValue, err)
+	}
+	if err := codec.Unmarshal(data, &v); err != nil {
+		return v, fmt.Errorf("%w: %v", ErrInvalidValue, err)
+	}
+
+	return v, nil
+}
@@ -0,0 +1,119 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type cart struct {
+	Items []string `json:"items"`
+	Total int      `json:"total"`
+}
+
+// codecRoundTrip encodes s the way a store using codec would and loads it
+// back.
+func codecRoundTrip(t *testing.T, codec Codec, s *Session) *Session {
+	t.Helper()
+
+	data := s.GetSessionData()
+	payload, err := EncodePayload(codec, &data)
+	require.NoError(t, err)
+
+	var sd SessionData
+	require.NoError(t, DecodePayload(payload, &sd))
+	loaded := NewSessionFromData(sd)
+	loaded.codec = codec
+	return loaded
+}
+
+func TestGetAs(t *testing.T) {
+	t.Run("should return values of the stored type", func(t *testing.T) {
+		s := NewSession(time.Hour)
+		SetAs(s, "count", 42)
+		SetAs(s, "cart", cart{Items: []string{"a"}, Total: 10})
+
+		count, err := GetAs[int](s, "count")
+		require.NoError(t, err)
+		assert.Equal(t, 42, count)
+
+		c, err := GetAs[cart](s, "cart")
+		require.NoError(t, err)
+		assert.Equal(t, cart{Items: []string{"a"}, Total: 10}, c)
+	})
+
+	t.Run("should return the same types after a store round trip", func(t *testing.T) {
+		s := NewSession(time.Hour)
+		SetAs(s, "count", 42)
+		SetAs(s, "cart", cart{Items: []string{"a"}, Total: 10})
+		SetAs(s, "ptr", &cart{Total: 3})
+
+		loaded := jsonRoundTrip(t, s)
+
+		raw, _ := loaded.Get("count")
+		assert.IsType(t, float64(0), raw)
+
+		count, err := GetAs[int](loaded, "count")
+		require.NoError(t, err)
+		assert.Equal(t, 42, count)
+
+		c, err := GetAs[cart](loaded, "cart")
+		require.NoError(t, err)
+		assert.Equal(t, cart{Items: []string{"a"}, Total: 10}, c)
+
+		p, err := GetAs[*cart](loaded, "ptr")
+		require.NoError(t, err)
+		assert.Equal(t, 3, p.Total)
+	})
+
+	t.Run("should return the stored types through every codec", func(t *testing.T) {
+		for _, codec := range []Codec{JSONCodec, GobCodec, MsgpackCodec, CBORCodec} {
+			s := NewSession(time.Hour)
+			SetAs(s, "count", 42)
+			SetAs(s, "cart", cart{Items: []string{"a"}, Total: 10})
+			SetAs(s, "ptr", &cart{Total: 3})
+
+			loaded := codecRoundTrip(t, codec, s)
+
+			count, err := GetAs[int](loaded, "count")
+			require.NoError(t, err, codec.Name())
+			assert.Equal(t, 42, count, codec.Name())
+
+			c, err := GetAs[cart](loaded, "cart")
+			require.NoError(t, err, codec.Name())
+			assert.Equal(t, cart{Items: []string{"a"}, Total: 10}, c, codec.Name())
+
+			p, err := GetAs[*cart](loaded, "ptr")
+			require.NoError(t, err, codec.Name())
+			assert.Equal(t, 3, p.Total, codec.Name())
+		}
+	})
+
+	t.Run("should return ErrKeyNotFound for missing keys", func(t *testing.T) {
+		s := NewSession(time.Hour)
+
+		_, err := GetAs[int](s, "missing")
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+	})
+
+	t.Run("should return ErrInvalidValue for incompatible values", func(t *testing.T) {
+		s := NewSession(time.Hour)
+		s.Set("count", "not a number")
+
+		_, err := GetAs[int](s, "count")
+		assert.ErrorIs(t, err, ErrInvalidValue)
+	})
+}
+
+func TestSetAs(t *testing.T) {
+	t.Run("should mark the session modified", func(t *testing.T) {
+		s := NewSession(time.Hour)
+		s.MarkClean()
+
+		SetAs(s, "count", 1)
+
+		assert.True(t, s.IsModified())
+	})
+}